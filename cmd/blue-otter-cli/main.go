@@ -3,22 +3,49 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	tcell "github.com/gdamore/tcell/v2"
+	"github.com/multiformats/go-multiaddr"
 	bootstrap "github.com/patrickma6199/blue-otter/internal/blue_otter_bootstrap"
 	client "github.com/patrickma6199/blue-otter/internal/blue_otter_client"
 	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	daemon "github.com/patrickma6199/blue-otter/internal/blue_otter_daemon"
 	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
 	tui "github.com/patrickma6199/blue-otter/internal/blue_otter_tui"
 	"github.com/rivo/tview"
 	"github.com/urfave/cli/v2"
 )
 
+// moderationCommands maps the TUI moderation commands to the moderation action they publish
+var moderationCommands = map[string]string{
+	"/ban":     client.ModerationBan,
+	"/unban":   client.ModerationUnban,
+	"/kick":    client.ModerationKick,
+	"/mute":    client.ModerationMute,
+	"/unmute":  client.ModerationUnmute,
+	"/promote": client.ModerationPromote,
+	"/demote":  client.ModerationDemote,
+}
+
+// Exit codes returned by the CLI
+const (
+	exitFailure    = 1
+	exitUsage      = 2
+	exitNoPeers    = 3
+	exitPortInUse  = 4
+	exitKeyCorrupt = 5
+	exitDHTFailed  = 6
+)
+
 func main() {
 	app := &cli.App{
 		Name:    "blue-otter-cli",
@@ -47,11 +74,29 @@ func main() {
 CLIENT NODE - v0.1.0                                                                           
 					`)
 
+					if c.String("invite") != "" {
+						room, err := acceptInvitation(c)
+						if err != nil {
+							return err
+						}
+						c.Set("room", room)
+					}
+
+					if c.Bool("create") {
+						if c.String("room") == "" {
+							return fmt.Errorf("no room specified. use --room or -r flag with --create")
+						}
+						room, err := client.OwnRoomName(c.String("room"))
+						if err != nil {
+							return err
+						}
+						c.Set("room", room)
+					}
+
 					if c.String("room") == "" {
 						fmt.Println("Room name was not provided. Using default: --blue-otter-public-default")
 						c.Set("room", "--blue-otter-public-default")
-					} else if !strings.HasPrefix(c.String("room"), "--blue-otter-") {
-						newRoom := "--blue-otter-" + c.String("room")
+					} else if newRoom := client.NormalizeRoomName(c.String("room")); newRoom != c.String("room") {
 						fmt.Printf("Room name modified to have required prefix: %s\n", newRoom)
 						c.Set("room", newRoom)
 					}
@@ -69,29 +114,75 @@ CLIENT NODE - v0.1.0
 						c.Set("username", "Guest")
 					}
 
+					addrs, err := listenAddrs(c)
+					if err != nil {
+						return err
+					}
+
+					limits, err := messageLimits(c)
+					if err != nil {
+						return err
+					}
+
+					resources, err := resourceLimits(c)
+					if err != nil {
+						return err
+					}
+
+					var roomKey *common.RoomKey
+					if c.String("room-secret") != "" {
+						fmt.Println("Deriving room key from room secret...")
+						key, err := common.DeriveRoomKey(c.String("room"), c.String("room-secret"))
+						if err != nil {
+							return err
+						}
+						roomKey = key
+					}
+
 					ctx, cancel := context.WithCancel(context.Background())
 					defer cancel()
 
-					// Create a quit channel for signaling termination
-					quitCh := make(chan struct{})
-
 					app := tview.NewApplication()
 
-					layout, _, chatView, systemLogView, inputField := tui.CreateUI(c.String("username"), c.String("room"))
+					layout, _, chatPages, userList, systemLogView, inputField := tui.CreateUI(c.String("username"), c.String("room"))
 
-					// Start the server and get the host
-					host, _, topic := client.StartServer(ctx, c.String("username"), c.String("room"), c.String("port"), quitCh, chatView, systemLogView)
-					defer host.Close()
+					// Start the server and get the session
+					events := tui.NewEventSink(app, chatPages, userList, systemLogView)
+					session, err := client.StartServer(ctx, c.String("username"), addrs, c.Bool("lan"), limits, resources, c.Int("history"), false, events)
+					if err != nil {
+						return startupExit(err)
+					}
+					defer session.Close()
+
+					// Join the initial room, which announces our arrival
+					if _, err := session.Join(c.String("room"), roomKey); err != nil {
+						return err
+					}
 
-					// Announce our arrival
-					joinMsg := common.SystemNotification{
-						Type:    "join",
-						Message: fmt.Sprintf("[%s] User %s has joined the room", c.String("room"), c.String("username")),
+					chatPages.View(c.String("room")).Write([]byte(fmt.Sprintf("[%s] Blue Otter started! Type /quit to exit.\n", c.String("room"))))
+
+					// switchRoom shows a joined room's pane and points the input at it
+					switchRoom := func(roomName string) {
+						session.Switch(roomName)
+						chatPages.Switch(roomName)
+						userList.Switch(roomName)
+						inputField.SetLabel(tui.InputLabel(roomName, c.String("username")))
 					}
-					joinData, _ := json.Marshal(joinMsg)
-					topic.Publish(ctx, joinData)
 
-					chatView.Write([]byte(fmt.Sprintf("[%s] Blue Otter started! Type /quit to exit.\n", c.String("room"))))
+					// showCurrentRoom points the input at whichever room is left after leaving one
+					showCurrentRoom := func() {
+						if current := session.Current(); current != nil {
+							switchRoom(current.Name)
+						} else {
+							inputField.SetLabel(tui.InputLabel("no room", c.String("username")))
+							systemLogView.Write([]byte("You are not in any room. Use /join <room> to join one.\n"))
+						}
+					}
+
+					// A ban makes the session leave a room by itself, after which its pane is already gone
+					events.SetPartedFunc(func(roomName string) {
+						showCurrentRoom()
+					})
 
 					// Set up the input field to send messages
 					inputField.SetDoneFunc(func(key tcell.Key) {
@@ -100,38 +191,103 @@ CLIENT NODE - v0.1.0
 						if strings.TrimSpace(text) == "" {
 							return
 						}
-						
+
+						chatView := chatPages.View("")
+
 						switch text {
 						case "/quit":
-							// Send leave message before quitting
-							leaveMsg := common.SystemNotification{
-								Type:    "leave",
-								Message: fmt.Sprintf("[%s] User %s has left the room", c.String("room"), c.String("username")),
-							}
-							leaveData, _ := json.Marshal(leaveMsg)
-							topic.Publish(ctx, leaveData)
+							// Send leave messages before quitting
+							session.PartAll()
 
 							systemLogView.Write([]byte("Shutting down Blue Otter...\n"))
 
 							app.Stop()
-							close(quitCh)
 							cancel()
 							return
 						case "/help":
 							systemLogView.Write([]byte("Available commands:\n"))
 							systemLogView.Write([]byte("/quit - Exit the chat\n"))
 							systemLogView.Write([]byte("/help - Show this help message\n"))
-							systemLogView.Write([]byte("/list - List all connected peers\n"))
+							systemLogView.Write([]byte("/list - List the members of the current room\n"))
+							systemLogView.Write([]byte("/status <status> - Set the status shown to other room members, e.g. away\n"))
+							systemLogView.Write([]byte("/block <user-or-peerID-or-CIDR> - Disconnect and refuse a peer or IP range\n"))
+							systemLogView.Write([]byte("/unblock <peerID-or-CIDR> - Allow a blocked peer or IP range again\n"))
+							systemLogView.Write([]byte("/msg <user-or-peerID> <text> - Send a private message to a single peer\n"))
+							systemLogView.Write([]byte("/mods - Show the owner, moderators, bans and mutes of the current room\n"))
+							systemLogView.Write([]byte("/ban <user-or-peerID> [duration] [reason] - Ban a member from the current room (owner and moderators)\n"))
+							systemLogView.Write([]byte("/kick <user-or-peerID> [reason] - Keep a member out of the current room for ten minutes\n"))
+							systemLogView.Write([]byte("/mute <user-or-peerID> [duration] [reason] - Hide a member's messages from everyone in the current room\n"))
+							systemLogView.Write([]byte("/unban, /unmute <user-or-peerID> - Lift a ban or mute\n"))
+							systemLogView.Write([]byte("/promote, /demote <user-or-peerID> - Make a member a moderator or take it back (owner only)\n"))
+							systemLogView.Write([]byte("/rooms - List public rooms advertised by other peers\n"))
+							systemLogView.Write([]byte("/resources - Show connections, streams, memory and file descriptors in use\n"))
+							systemLogView.Write([]byte("/join <room> [secret] - Join another room, optionally encrypted with a shared secret\n"))
+							systemLogView.Write([]byte("/create <room> [secret] - Create and join a room owned by you, named <room>@<your peer ID>\n"))
+							systemLogView.Write([]byte("/part [room] - Leave a room (defaults to the current room)\n"))
+							systemLogView.Write([]byte("/switch [room] - Switch to a joined room, or list joined rooms\n"))
+							systemLogView.Write([]byte("/history <n> - Show n older messages from local history\n"))
 							systemLogView.Write([]byte("/clear - Clear the chat window\n"))
 							systemLogView.Write([]byte("/clear-log - Clear the system log window\n"))
 							systemLogView.Write([]byte("/clear-all - Clear both chat and system log windows\n"))
 							return
 						case "/list":
-							// List all connected peers
-							peers := host.Peerstore().Peers()
-							systemLogView.Write([]byte("Connected peers:\n"))
-							for _, peer := range peers {
-								systemLogView.Write([]byte(fmt.Sprintf("- %s\n", peer.String())))
+							// List the members of the current room
+							current := session.Current()
+							if current == nil {
+								systemLogView.Write([]byte("You are not in any room. Use /join <room> to join one.\n"))
+								return
+							}
+							systemLogView.Write([]byte(fmt.Sprintf("Members of %s:\n", current.Name)))
+							for _, member := range current.Members() {
+								systemLogView.Write([]byte(fmt.Sprintf("- %s %s\n", member, member.PeerID)))
+							}
+						case "/rooms":
+							systemLogView.Write([]byte("Looking for public rooms...\n"))
+							// Browsing asks peers over the network, so keep it off the UI goroutine
+							go func() {
+								browseCtx, browseCancel := context.WithTimeout(ctx, 30*time.Second)
+								defer browseCancel()
+
+								rooms, err := session.BrowseRooms(browseCtx)
+								if err != nil {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to browse rooms: %s\n", err)))
+									return
+								}
+								if len(rooms) == 0 {
+									systemLogView.Write([]byte("No public rooms found.\n"))
+									return
+								}
+								systemLogView.Write([]byte("Public rooms:\n"))
+								for _, room := range rooms {
+									systemLogView.Write([]byte(fmt.Sprintf("- %s\n", describePublicRoom(room))))
+								}
+							}()
+						case "/resources":
+							systemLogView.Write([]byte("Resource usage:\n"))
+							for _, line := range session.ResourceUsage().Lines() {
+								systemLogView.Write([]byte(fmt.Sprintf("- %s\n", line)))
+							}
+						case "/mods":
+							current := session.Current()
+							if current == nil {
+								systemLogView.Write([]byte("You are not in any room. Use /join <room> to join one.\n"))
+								return
+							}
+							state := current.Moderation()
+							if state.Owner == "" {
+								systemLogView.Write([]byte(fmt.Sprintf("%s has no owner. Use /create <room> to create a room you own.\n", current.Name)))
+								return
+							}
+							systemLogView.Write([]byte(fmt.Sprintf("Moderation of %s:\n", current.Name)))
+							systemLogView.Write([]byte(fmt.Sprintf("- owner %s\n", state.Owner)))
+							for _, id := range state.Moderators {
+								systemLogView.Write([]byte(fmt.Sprintf("- moderator %s\n", id)))
+							}
+							for id, until := range state.Banned {
+								systemLogView.Write([]byte(fmt.Sprintf("- banned %s%s\n", id, untilSuffix(until))))
+							}
+							for id, until := range state.Muted {
+								systemLogView.Write([]byte(fmt.Sprintf("- muted %s%s\n", id, untilSuffix(until))))
 							}
 						case "/clear":
 							// Clear the chat window
@@ -146,47 +302,274 @@ CLIENT NODE - v0.1.0
 							chatView.SetText("")
 							systemLogView.SetText("")
 							systemLogView.Write([]byte("Both chat and system log windows cleared.\n"))
+						case "/switch":
+							systemLogView.Write([]byte("Joined rooms:\n"))
+							for _, roomName := range session.RoomNames() {
+								marker := ""
+								if roomName == chatPages.Active() {
+									marker = " (current)"
+								}
+								systemLogView.Write([]byte(fmt.Sprintf("- %s%s\n", roomName, marker)))
+							}
 						default:
+							if strings.HasPrefix(text, "/msg ") {
+								parts := strings.SplitN(text, " ", 3)
+								if len(parts) < 3 || strings.TrimSpace(parts[2]) == "" {
+									systemLogView.Write([]byte("Usage: /msg <user-or-peerID> <text>\n"))
+									return
+								}
+
+								// Deliver off the UI goroutine since opening the stream may need to dial the peer
+								go func() {
+									to, err := session.DirectMessenger.Send(ctx, parts[1], c.String("username"), parts[2])
+									if err != nil {
+										systemLogView.Write([]byte(fmt.Sprintf("[DM] Failed to send: %s\n", err)))
+										return
+									}
+									chatView.Write([]byte(fmt.Sprintf("[DM to %s ✓%s]: %s\n", parts[1], client.Fingerprint(to), parts[2])))
+								}()
+								return
+							}
+
+							if strings.HasPrefix(text, "/join ") || strings.HasPrefix(text, "/create ") {
+								parts := strings.Fields(text)
+								if len(parts) < 2 || len(parts) > 3 {
+									systemLogView.Write([]byte(fmt.Sprintf("Usage: %s <room> [secret]\n", parts[0])))
+									return
+								}
+
+								// Created rooms carry our peer ID in their name, which makes us their owner
+								roomName := client.NormalizeRoomName(parts[1])
+								if parts[0] == "/create" {
+									roomName = client.OwnedRoomName(parts[1], session.Host.ID())
+								}
+								if _, found := session.Room(roomName); found {
+									systemLogView.Write([]byte(fmt.Sprintf("Already in %s. Use /switch %s to view it.\n", roomName, roomName)))
+									return
+								}
+
+								// Create the pane up front so messages arriving during the join have somewhere to go
+								chatPages.View(roomName)
+
+								// Join off the UI goroutine since deriving a room key is deliberately slow
+								go func() {
+									// A failed join leaves no pane behind
+									failed := func(err error) {
+										app.QueueUpdateDraw(func() {
+											if _, found := session.Room(roomName); !found {
+												chatPages.Remove(roomName)
+											}
+										})
+										systemLogView.Write([]byte(fmt.Sprintf("Failed to join %s: %s\n", roomName, err)))
+									}
+
+									var joinKey *common.RoomKey
+									if len(parts) == 3 {
+										key, err := common.DeriveRoomKey(roomName, parts[2])
+										if err != nil {
+											failed(err)
+											return
+										}
+										joinKey = key
+									}
+
+									if _, err := session.Join(roomName, joinKey); err != nil {
+										failed(err)
+										return
+									}
+
+									app.QueueUpdateDraw(func() {
+										switchRoom(roomName)
+										chatPages.View(roomName).Write([]byte(fmt.Sprintf("[%s] Joined room.\n", roomName)))
+									})
+								}()
+								return
+							}
+
+							if strings.HasPrefix(text, "/block ") {
+								target := strings.TrimSpace(strings.TrimPrefix(text, "/block "))
+
+								// Usernames seen in a room are resolved to their peer ID
+								if _, _, err := management.ParseAccessEntry(target); err != nil {
+									id, err := session.DirectMessenger.Resolve(target)
+									if err != nil {
+										systemLogView.Write([]byte(fmt.Sprintf("Failed to block %s: %s\n", target, err)))
+										return
+									}
+									if id == session.Host.ID() {
+										systemLogView.Write([]byte("You cannot block yourself.\n"))
+										return
+									}
+									target = id.String()
+								}
+
+								entry, err := session.Gater.Block(session.Host, target)
+								if err != nil {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to block %s: %s\n", target, err)))
+									return
+								}
+								systemLogView.Write([]byte(fmt.Sprintf("Blocked %s.\n", entry)))
+								return
+							}
+
+							if strings.HasPrefix(text, "/unblock ") {
+								entry, err := session.Gater.Unblock(strings.TrimSpace(strings.TrimPrefix(text, "/unblock ")))
+								if err != nil {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to unblock: %s\n", err)))
+									return
+								}
+								systemLogView.Write([]byte(fmt.Sprintf("Unblocked %s.\n", entry)))
+								return
+							}
+
+							if parts := strings.Fields(text); len(parts) > 0 && moderationCommands[parts[0]] != "" {
+								action := moderationCommands[parts[0]]
+								if len(parts) < 2 {
+									systemLogView.Write([]byte(fmt.Sprintf("Usage: %s <user-or-peerID> [duration] [reason]\n", parts[0])))
+									return
+								}
+
+								current := session.Current()
+								if current == nil {
+									systemLogView.Write([]byte("You are not in any room. Use /join <room> to join one.\n"))
+									return
+								}
+
+								target, err := session.DirectMessenger.Resolve(parts[1])
+								if err != nil {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to %s %s: %s\n", action, parts[1], err)))
+									return
+								}
+
+								// An optional duration comes first, anything after it is the reason
+								var duration time.Duration
+								rest := parts[2:]
+								if len(rest) > 0 {
+									if d, err := time.ParseDuration(rest[0]); err == nil && d > 0 {
+										duration = d
+										rest = rest[1:]
+									}
+								}
+
+								if err := session.Moderate(current.Name, action, target, duration, strings.Join(rest, " ")); err != nil {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to %s %s: %s\n", action, parts[1], err)))
+								}
+								return
+							}
+
+							if strings.HasPrefix(text, "/status ") {
+								if err := session.SetStatus(strings.TrimPrefix(text, "/status ")); err != nil {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to set status: %s\n", err)))
+									return
+								}
+								systemLogView.Write([]byte(fmt.Sprintf("Status set to %s.\n", session.Status())))
+								return
+							}
+
+							if text == "/part" || strings.HasPrefix(text, "/part ") {
+								roomName := chatPages.Active()
+								if parts := strings.Fields(text); len(parts) > 1 {
+									roomName = client.NormalizeRoomName(parts[1])
+								}
+
+								if err := session.Part(roomName); err != nil {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to leave %s: %s\n", roomName, err)))
+									return
+								}
+
+								chatPages.Remove(roomName)
+								userList.Remove(roomName)
+								systemLogView.Write([]byte(fmt.Sprintf("Left %s.\n", roomName)))
+								showCurrentRoom()
+								return
+							}
+
+							if strings.HasPrefix(text, "/history ") {
+								n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(text, "/history ")))
+								if err != nil || n <= 0 {
+									systemLogView.Write([]byte("Usage: /history <n>\n"))
+									return
+								}
+
+								current := session.Current()
+								if current == nil {
+									systemLogView.Write([]byte("You are not in any room. Use /join <room> to join one.\n"))
+									return
+								}
+
+								messages, err := session.ScrollBack(current.Name, n)
+								if err != nil {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to load history: %s\n", err)))
+									return
+								}
+								if len(messages) == 0 {
+									systemLogView.Write([]byte("No older messages in local history.\n"))
+									return
+								}
+
+								// Older messages go above everything already on screen
+								var older strings.Builder
+								for _, msg := range messages {
+									older.WriteString(msg.HistoryLine() + "\n")
+								}
+								chatView.SetText(older.String() + chatView.GetText(false))
+								chatView.ScrollToBeginning()
+								return
+							}
+
+							if strings.HasPrefix(text, "/switch ") {
+								roomName := client.NormalizeRoomName(strings.TrimSpace(strings.TrimPrefix(text, "/switch ")))
+								if _, found := session.Room(roomName); !found {
+									systemLogView.Write([]byte(fmt.Sprintf("Failed to switch to %s: not in room\n", roomName)))
+									return
+								}
+
+								switchRoom(roomName)
+								return
+							}
+
 							// Handle other commands or messages
 							if strings.HasPrefix(text, "/") {
 								systemLogView.Write([]byte(fmt.Sprintf("Unknown command: %s\n", text)))
 								return
 							}
 
-							msg := common.ChatMessage{Sender: c.String("username"), Text: text}
-							data, err := json.Marshal(msg)
-							if err != nil {
-								systemLogView.Write([]byte(fmt.Sprintf("Error encoding message: %s\n", err)))
+							current := session.Current()
+							if current == nil {
+								systemLogView.Write([]byte("You are not in any room. Use /join <room> to join one.\n"))
 								return
 							}
 
-							topic.Publish(ctx, data)
+							if err := session.Send(ctx, current.Name, text); err != nil {
+								systemLogView.Write([]byte(fmt.Sprintf("Error sending message: %s\n", err)))
+								return
+							}
 						}
 					})
 
 					app.SetFocus(inputField)
-					chatView.SetChangedFunc(func() {
+					chatPages.SetChangedFunc(func(chatView *tview.TextView) {
 						app.QueueUpdateDraw(func() {
 							chatView.ScrollToEnd()
 						})
 					})
+					userList.SetChangedFunc(func() {
+						app.QueueUpdateDraw(func() {})
+					})
 					systemLogView.SetChangedFunc(func() {
 						app.QueueUpdateDraw(func() {
 							systemLogView.ScrollToEnd()
 						})
 					})
 
-					// Start the TUI application
+					// Start the TUI application, which returns once /quit stops it
 					if err := app.SetRoot(layout, true).Run(); err != nil {
-						panic(err)
+						return cli.Exit(fmt.Sprintf("terminal UI failed: %v", err), exitFailure)
 					}
 
-					// Wait for quit signal
-					<-quitCh
-
 					return nil
 				},
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "username",
 						Aliases: []string{"u"},
@@ -197,11 +580,333 @@ CLIENT NODE - v0.1.0
 						Aliases: []string{"r"},
 						Usage:   "Room name to join",
 					},
+					&cli.BoolFlag{
+						Name:  "create",
+						Usage: "Create the room named by --room as a room owned by you, named <room>@<your peer ID>",
+					},
+					&cli.StringFlag{
+						Name:    "port",
+						Aliases: []string{"p"},
+						Usage:   "Port to run the Blue Otter service on",
+					},
+					&cli.StringSliceFlag{
+						Name:  "listen",
+						Usage: "Multiaddr to listen on, may be repeated (overrides --port, default: TCP, WebSocket, QUIC and WebTransport over IPv4 and IPv6)",
+					},
+					&cli.BoolFlag{
+						Name:  "lan",
+						Usage: "Discover peers on the local network with mDNS, no bootstrap node needed",
+					},
+					&cli.IntFlag{
+						Name:  "history",
+						Usage: "Number of messages from local history to show when joining a room",
+						Value: 50,
+					},
+					&cli.StringFlag{
+						Name:    "room-secret",
+						Aliases: []string{"s"},
+						Usage:   "Shared passphrase used to end-to-end encrypt the room",
+					},
+					&cli.StringFlag{
+						Name:  "invite",
+						Usage: "Invitation token to join an invite-only room with (replaces --room)",
+					},
+					&cli.BoolFlag{
+						Name:  "replace-key",
+						Usage: "Replace the key saved for the room with the one in the --invite token",
+					},
+					&cli.IntFlag{
+						Name:  "max-message-size",
+						Usage: "Largest room message accepted from other peers, in bytes",
+						Value: client.DefaultMessageLimits().MaxSize,
+					},
+					&cli.Float64Flag{
+						Name:  "max-message-rate",
+						Usage: "Messages per second each peer may publish to a room before being rejected",
+						Value: client.DefaultMessageLimits().Rate,
+					},
+					&cli.IntFlag{
+						Name:  "max-message-burst",
+						Usage: "Messages each peer may publish at once before --max-message-rate applies",
+						Value: client.DefaultMessageLimits().Burst,
+					},
+				}, resourceFlags(common.DefaultClientResourceLimits())...),
+			},
+			{
+				Name:    "daemon",
+				Aliases: []string{"d"},
+				Usage:   "Run the Blue Otter client headless, controlled through a local JSON-RPC socket",
+				Action: func(c *cli.Context) error {
+					if c.String("port") == "" {
+						c.Set("port", "42069")
+					} else if _, err := strconv.Atoi(c.String("port")); err != nil {
+						fmt.Println("Port must be a number. Using default: 42069")
+						c.Set("port", "42069")
+					}
+
+					if c.String("username") == "" {
+						fmt.Println("No username provided. Using default: Guest")
+						c.Set("username", "Guest")
+					}
+
+					addrs, err := listenAddrs(c)
+					if err != nil {
+						return err
+					}
+
+					limits, err := messageLimits(c)
+					if err != nil {
+						return err
+					}
+
+					resources, err := resourceLimits(c)
+					if err != nil {
+						return err
+					}
+
+					if c.String("socket") == "" {
+						if err := management.EnsureConfigDir(); err != nil {
+							return fmt.Errorf("failed to create config directory: %w", err)
+						}
+						socketPath, err := management.GetDaemonSocketPath()
+						if err != nil {
+							return fmt.Errorf("failed to resolve socket path: %w", err)
+						}
+						c.Set("socket", socketPath)
+					}
+
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+
+					// Everything is logged to stdout, and chat also reaches scripts through "subscribe"
+					hub := daemon.NewEventHub(os.Stdout)
+					session, err := client.StartServer(ctx, c.String("username"), addrs, c.Bool("lan"), limits, resources, 0, false, hub)
+					if err != nil {
+						return startupExit(err)
+					}
+					defer session.Close()
+
+					if c.String("invite") != "" {
+						room, err := acceptInvitation(c)
+						if err != nil {
+							return err
+						}
+						c.Set("room", room)
+					}
+
+					if c.String("room") != "" {
+						var roomKey *common.RoomKey
+						roomName := client.NormalizeRoomName(c.String("room"))
+						if c.Bool("create") {
+							roomName = client.OwnedRoomName(c.String("room"), session.Host.ID())
+						}
+						if c.String("room-secret") != "" {
+							key, err := common.DeriveRoomKey(roomName, c.String("room-secret"))
+							if err != nil {
+								return err
+							}
+							roomKey = key
+						}
+
+						if _, err := session.Join(roomName, roomKey); err != nil {
+							return err
+						}
+						fmt.Printf("[Daemon] Joined %s\n", roomName)
+					}
+
+					server, err := daemon.Serve(ctx, session, hub, c.String("socket"))
+					if err != nil {
+						return err
+					}
+					defer server.Close()
+
+					fmt.Printf("[Daemon] Control socket listening on %s\n", c.String("socket"))
+
+					// Run until interrupted
+					sigCh := make(chan os.Signal, 1)
+					signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+					<-sigCh
+
+					fmt.Println("[Daemon] Shutting down...")
+					session.PartAll()
+
+					return nil
+				},
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "username",
+						Aliases: []string{"u"},
+						Usage:   "Username to publish messages under",
+					},
+					&cli.StringFlag{
+						Name:    "room",
+						Aliases: []string{"r"},
+						Usage:   "Room to join on startup (more can be joined through the socket)",
+					},
+					&cli.BoolFlag{
+						Name:  "create",
+						Usage: "Create the startup room as a room owned by you, named <room>@<your peer ID>",
+					},
+					&cli.StringFlag{
+						Name:    "room-secret",
+						Aliases: []string{"s"},
+						Usage:   "Shared passphrase for the startup room",
+					},
+					&cli.StringFlag{
+						Name:  "invite",
+						Usage: "Invitation token to join an invite-only room with at startup (replaces --room)",
+					},
+					&cli.BoolFlag{
+						Name:  "replace-key",
+						Usage: "Replace the key saved for the room with the one in the --invite token",
+					},
 					&cli.StringFlag{
 						Name:    "port",
 						Aliases: []string{"p"},
 						Usage:   "Port to run the Blue Otter service on",
 					},
+					&cli.StringSliceFlag{
+						Name:  "listen",
+						Usage: "Multiaddr to listen on, may be repeated (overrides --port, default: TCP, WebSocket, QUIC and WebTransport over IPv4 and IPv6)",
+					},
+					&cli.BoolFlag{
+						Name:  "lan",
+						Usage: "Discover peers on the local network with mDNS, no bootstrap node needed",
+					},
+					&cli.StringFlag{
+						Name:  "socket",
+						Usage: "Path of the control socket (default: ~/.blue-otter/daemon.sock)",
+					},
+					&cli.IntFlag{
+						Name:  "max-message-size",
+						Usage: "Largest room message accepted from other peers, in bytes",
+						Value: client.DefaultMessageLimits().MaxSize,
+					},
+					&cli.Float64Flag{
+						Name:  "max-message-rate",
+						Usage: "Messages per second each peer may publish to a room before being rejected",
+						Value: client.DefaultMessageLimits().Rate,
+					},
+					&cli.IntFlag{
+						Name:  "max-message-burst",
+						Usage: "Messages each peer may publish at once before --max-message-rate applies",
+						Value: client.DefaultMessageLimits().Burst,
+					},
+				}, resourceFlags(common.DefaultClientResourceLimits())...),
+			},
+			{
+				Name:      "send",
+				Usage:     "Post a single message to a room and exit, for scripts and CI pipelines",
+				ArgsUsage: " ",
+				Action: func(c *cli.Context) error {
+					if c.String("room") == "" {
+						return cli.Exit("no room specified. use --room or -r flag", exitUsage)
+					}
+					roomName := client.NormalizeRoomName(c.String("room"))
+
+					if _, err := strconv.Atoi(c.String("port")); err != nil {
+						return cli.Exit("port must be a number", exitUsage)
+					}
+					addrs, err := listenAddrs(c)
+					if err != nil {
+						return cli.Exit(err.Error(), exitUsage)
+					}
+
+					text := c.String("message")
+					if text == "" || text == "-" {
+						data, err := io.ReadAll(os.Stdin)
+						if err != nil {
+							return cli.Exit(fmt.Sprintf("failed to read message from stdin: %s", err), exitFailure)
+						}
+						text = strings.TrimRight(string(data), "\r\n")
+					}
+					if strings.TrimSpace(text) == "" {
+						return cli.Exit("message is empty. use --message or pipe it on stdin", exitUsage)
+					}
+
+					var roomKey *common.RoomKey
+					if c.String("room-secret") != "" {
+						key, err := common.DeriveRoomKey(roomName, c.String("room-secret"))
+						if err != nil {
+							return cli.Exit(err.Error(), exitFailure)
+						}
+						roomKey = key
+					}
+
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+
+					// Network progress is only interesting when something goes wrong
+					var events client.EventSink = client.EventSinkFunc(func(client.Event) {})
+					if c.Bool("verbose") {
+						events = client.NewWriterSink(os.Stderr)
+					}
+
+					session, err := client.StartServer(ctx, c.String("username"), addrs, c.Bool("lan"), client.DefaultMessageLimits(), common.DefaultClientResourceLimits(), 0, true, events)
+					if err != nil {
+						return startupExit(err)
+					}
+					defer session.Close()
+
+					sendCtx, sendCancel := context.WithTimeout(ctx, c.Duration("timeout"))
+					defer sendCancel()
+
+					if err := session.SendOnce(sendCtx, roomName, roomKey, text); err != nil {
+						if errors.Is(err, client.ErrNoRoomPeers) {
+							return cli.Exit(fmt.Sprintf("no members of %s reachable within %s", roomName, c.Duration("timeout")), exitNoPeers)
+						}
+						return cli.Exit(fmt.Sprintf("failed to send message: %s", err), exitFailure)
+					}
+
+					fmt.Printf("Message sent to %s\n", roomName)
+					return nil
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "room",
+						Aliases: []string{"r"},
+						Usage:   "Room to post to",
+					},
+					&cli.StringFlag{
+						Name:    "username",
+						Aliases: []string{"u"},
+						Usage:   "Username to post as",
+						Value:   "Guest",
+					},
+					&cli.StringFlag{
+						Name:    "message",
+						Aliases: []string{"m"},
+						Usage:   "Message to post. Read from stdin when omitted or -",
+					},
+					&cli.StringFlag{
+						Name:    "room-secret",
+						Aliases: []string{"s"},
+						Usage:   "Shared passphrase of an encrypted room",
+					},
+					&cli.StringFlag{
+						Name:    "port",
+						Aliases: []string{"p"},
+						Usage:   "Port to listen on while sending (0 picks a free port)",
+						Value:   "0",
+					},
+					&cli.StringSliceFlag{
+						Name:  "listen",
+						Usage: "Multiaddr to listen on, may be repeated (overrides --port, default: TCP, WebSocket, QUIC and WebTransport over IPv4 and IPv6)",
+					},
+					&cli.BoolFlag{
+						Name:  "lan",
+						Usage: "Discover peers on the local network with mDNS, no bootstrap node needed",
+					},
+					&cli.DurationFlag{
+						Name:    "timeout",
+						Aliases: []string{"t"},
+						Usage:   "How long to wait for a room member to be reachable",
+						Value:   30 * time.Second,
+					},
+					&cli.BoolFlag{
+						Name:  "verbose",
+						Usage: "Log networking progress to stderr",
+					},
 				},
 			},
 			{
@@ -228,16 +933,40 @@ BOOTSTRAP NODE - P2P Network Entry Point - v0.1.0
 						c.Set("port", "42069")
 					}
 
+					addrs, err := listenAddrs(c)
+					if err != nil {
+						return err
+					}
+
 					ctx, cancel := context.WithCancel(context.Background())
 					defer cancel()
 
 					// Create a quit channel for signaling termination
 					quitCh := make(chan struct{})
 
+					relayConfig := bootstrap.RelayConfig{
+						Enabled:         c.Bool("relay"),
+						MaxReservations: c.Int("relay-max-reservations"),
+						MaxCircuits:     c.Int("relay-max-circuits"),
+						ReservationTTL:  c.Duration("relay-reservation-ttl"),
+						MaxDuration:     c.Duration("relay-max-duration"),
+						MaxData:         c.Int64("relay-max-data"),
+					}
+
+					limits, err := resourceLimits(c)
+					if err != nil {
+						return err
+					}
+					resources, err := common.NewHostResources(limits)
+					if err != nil {
+						return err
+					}
+
 					// Start the bootstrap node
-					host, err := bootstrap.StartBootstrapNode(ctx, c.String("port"), quitCh)
+					host, err := bootstrap.StartBootstrapNode(ctx, addrs, relayConfig, resources, quitCh)
 					if err != nil {
-						return fmt.Errorf("failed to start bootstrap node: %w", err)
+						resources.Close()
+						return startupExit(err)
 					}
 					defer host.Close()
 
@@ -262,6 +991,7 @@ BOOTSTRAP NODE - P2P Network Entry Point - v0.1.0
 								fmt.Println("/quit - Exit the bootstrap node")
 								fmt.Println("/help - Show this help message")
 								fmt.Println("/list - List all connected peers")
+								fmt.Println("/resources - Show connections, streams, memory and file descriptors in use")
 								fmt.Println("/clear - Clear the console")
 							case "/list":
 								// List all connected peers
@@ -270,6 +1000,11 @@ BOOTSTRAP NODE - P2P Network Entry Point - v0.1.0
 								for _, peer := range peers {
 									fmt.Printf("- %s\n", peer.String())
 								}
+							case "/resources":
+								fmt.Println("Resource usage:")
+								for _, line := range resources.Usage(host).Lines() {
+									fmt.Printf("- %s\n", line)
+								}
 							case "/clear":
 								// Clear the console
 								fmt.Print("\033[H\033[2J")
@@ -289,13 +1024,46 @@ BOOTSTRAP NODE - P2P Network Entry Point - v0.1.0
 
 					return nil
 				},
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "port",
 						Aliases: []string{"p"},
 						Usage:   "Port to run the bootstrap node on",
 					},
-				},
+					&cli.StringSliceFlag{
+						Name:  "listen",
+						Usage: "Multiaddr to listen on, may be repeated (overrides --port, default: TCP, WebSocket, QUIC and WebTransport over IPv4 and IPv6)",
+					},
+					&cli.BoolFlag{
+						Name:  "relay",
+						Usage: "Relay traffic for clients behind NAT that cannot reach each other directly",
+					},
+					&cli.IntFlag{
+						Name:  "relay-max-reservations",
+						Usage: "Maximum number of clients holding a relay slot at once",
+						Value: bootstrap.DefaultRelayConfig().MaxReservations,
+					},
+					&cli.IntFlag{
+						Name:  "relay-max-circuits",
+						Usage: "Maximum number of relayed connections open per client",
+						Value: bootstrap.DefaultRelayConfig().MaxCircuits,
+					},
+					&cli.DurationFlag{
+						Name:  "relay-reservation-ttl",
+						Usage: "How long a relay reservation lasts before it must be refreshed",
+						Value: bootstrap.DefaultRelayConfig().ReservationTTL,
+					},
+					&cli.DurationFlag{
+						Name:  "relay-max-duration",
+						Usage: "How long a single relayed connection may stay open",
+						Value: bootstrap.DefaultRelayConfig().MaxDuration,
+					},
+					&cli.Int64Flag{
+						Name:  "relay-max-data",
+						Usage: "Bytes a single relayed connection may carry in each direction",
+						Value: bootstrap.DefaultRelayConfig().MaxData,
+					},
+				}, resourceFlags(common.DefaultBootstrapResourceLimits())...),
 			},
 			{
 				Name:    "add-bootstrap",
@@ -308,14 +1076,21 @@ BOOTSTRAP NODE - P2P Network Entry Point - v0.1.0
 
 					address := c.String("address")
 
-					// Account for windows paths in powershell
+					// Account for windows paths in powershell, which prepend the drive to anything starting with a slash
 					normalizedAddr := address
 					if strings.HasPrefix(address, "C:/") {
-						if idx := strings.Index(address, "/ip4"); idx != -1 {
-							normalizedAddr = address[idx:]
+						for _, proto := range []string{"/ip4/", "/ip6/", "/dns/", "/dns4/", "/dns6/", "/dnsaddr/"} {
+							if idx := strings.Index(address, proto); idx != -1 {
+								normalizedAddr = address[idx:]
+								break
+							}
 						}
 					}
 
+					if _, err := multiaddr.NewMultiaddr(normalizedAddr); err != nil {
+						return fmt.Errorf("invalid bootstrap address: %w", err)
+					}
+
 					if err := management.AddBootstrapAddress(normalizedAddr); err != nil {
 						return fmt.Errorf("failed to add bootstrap address: %w", err)
 					}
@@ -327,7 +1102,7 @@ BOOTSTRAP NODE - P2P Network Entry Point - v0.1.0
 					&cli.StringFlag{
 						Name:    "address",
 						Aliases: []string{"a"},
-						Usage:   "Bootstrap node address to add (e.g. /ip4/127.0.0.1/tcp/42069/p2p/QmHashValue)",
+						Usage:   "Bootstrap node address to add, over any transport (e.g. /ip4/127.0.0.1/tcp/42069/p2p/QmHashValue or /ip6/::1/udp/42069/quic-v1/p2p/QmHashValue)",
 					},
 				},
 			},
@@ -379,6 +1154,406 @@ BOOTSTRAP NODE - P2P Network Entry Point - v0.1.0
 					return nil
 				},
 			},
+			{
+				Name:    "allow",
+				Aliases: []string{"al"},
+				Usage:   "Manage the peers and IP ranges allowed to connect. When the list is not empty, everyone else is refused",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "add",
+						Usage: "Allow a peer ID or IP range",
+						Action: func(c *cli.Context) error {
+							if c.String("entry") == "" {
+								return fmt.Errorf("no entry specified. use --entry or -e flag")
+							}
+
+							entry, err := management.AddAccessEntry(management.AllowList, c.String("entry"))
+							if err != nil {
+								return fmt.Errorf("failed to add %s to the allow list: %w", c.String("entry"), err)
+							}
+
+							fmt.Printf("'%s' added to the allow list. Running nodes pick it up when restarted\n", entry)
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "entry",
+								Aliases: []string{"e"},
+								Usage:   "Peer ID, IP address or CIDR range (e.g. 12D3KooW... or 203.0.113.0/24)",
+							},
+						},
+					},
+					{
+						Name:  "remove",
+						Usage: "Stop allowing a peer ID or IP range",
+						Action: func(c *cli.Context) error {
+							if c.String("entry") == "" {
+								return fmt.Errorf("no entry specified. use --entry or -e flag")
+							}
+
+							entry, err := management.RemoveAccessEntry(management.AllowList, c.String("entry"))
+							if err != nil {
+								return fmt.Errorf("failed to remove %s from the allow list: %w", c.String("entry"), err)
+							}
+
+							fmt.Printf("'%s' removed from the allow list\n", entry)
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "entry",
+								Aliases: []string{"e"},
+								Usage:   "Peer ID, IP address or CIDR range to remove",
+							},
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List the entries of the allow list",
+						Action: func(c *cli.Context) error {
+							lists, err := management.LoadAccessLists()
+							if err != nil {
+								return fmt.Errorf("failed to load access lists: %w", err)
+							}
+
+							if len(lists.Allow) == 0 {
+								fmt.Println("The allow list is empty")
+								return nil
+							}
+
+							fmt.Println("Allow list:")
+							for i, entry := range lists.Allow {
+								fmt.Printf("%d. %s\n", i+1, entry)
+							}
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:    "deny",
+				Aliases: []string{"dn"},
+				Usage:   "Manage the peers and IP ranges refused a connection",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "add",
+						Usage: "Block a peer ID or IP range",
+						Action: func(c *cli.Context) error {
+							if c.String("entry") == "" {
+								return fmt.Errorf("no entry specified. use --entry or -e flag")
+							}
+
+							entry, err := management.AddAccessEntry(management.DenyList, c.String("entry"))
+							if err != nil {
+								return fmt.Errorf("failed to add %s to the deny list: %w", c.String("entry"), err)
+							}
+
+							fmt.Printf("'%s' added to the deny list. Running nodes pick it up when restarted\n", entry)
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "entry",
+								Aliases: []string{"e"},
+								Usage:   "Peer ID, IP address or CIDR range (e.g. 12D3KooW... or 203.0.113.0/24)",
+							},
+						},
+					},
+					{
+						Name:  "remove",
+						Usage: "Unblock a peer ID or IP range",
+						Action: func(c *cli.Context) error {
+							if c.String("entry") == "" {
+								return fmt.Errorf("no entry specified. use --entry or -e flag")
+							}
+
+							entry, err := management.RemoveAccessEntry(management.DenyList, c.String("entry"))
+							if err != nil {
+								return fmt.Errorf("failed to remove %s from the deny list: %w", c.String("entry"), err)
+							}
+
+							fmt.Printf("'%s' removed from the deny list\n", entry)
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "entry",
+								Aliases: []string{"e"},
+								Usage:   "Peer ID, IP address or CIDR range to remove",
+							},
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List the entries of the deny list",
+						Action: func(c *cli.Context) error {
+							lists, err := management.LoadAccessLists()
+							if err != nil {
+								return fmt.Errorf("failed to load access lists: %w", err)
+							}
+
+							if len(lists.Deny) == 0 {
+								fmt.Println("The deny list is empty")
+								return nil
+							}
+
+							fmt.Println("Deny list:")
+							for i, entry := range lists.Deny {
+								fmt.Printf("%d. %s\n", i+1, entry)
+							}
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:    "swarm-key",
+				Aliases: []string{"sk"},
+				Usage:   "Manage the pre-shared key that isolates a private Blue Otter network",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "generate",
+						Usage: "Generate a new swarm key, making this node part of a new private network",
+						Action: func(c *cli.Context) error {
+							psk, err := management.GenerateSwarmKey(c.Bool("force"))
+							if errors.Is(err, management.ErrSwarmKeyExists) {
+								return fmt.Errorf("%w. use --force to replace it", err)
+							}
+							if err != nil {
+								return fmt.Errorf("failed to generate swarm key: %w", err)
+							}
+
+							keyPath, _ := management.GetSwarmKeyPath()
+							fmt.Printf("Swarm key saved to %s (fingerprint %s)\n", keyPath, management.SwarmKeyFingerprint(psk))
+							fmt.Println("Share it with 'swarm-key export' with every member of the network, including bootstrap nodes")
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:    "force",
+								Aliases: []string{"f"},
+								Usage:   "Replace an existing swarm key",
+							},
+						},
+					},
+					{
+						Name:  "import",
+						Usage: "Import a swarm key to join an existing private network",
+						Action: func(c *cli.Context) error {
+							var data []byte
+							var err error
+							if c.String("file") == "" || c.String("file") == "-" {
+								data, err = io.ReadAll(os.Stdin)
+							} else {
+								data, err = os.ReadFile(c.String("file"))
+							}
+							if err != nil {
+								return fmt.Errorf("failed to read swarm key: %w", err)
+							}
+
+							psk, err := management.ImportSwarmKey(data, c.Bool("force"))
+							if errors.Is(err, management.ErrSwarmKeyExists) {
+								return fmt.Errorf("%w. use --force to replace it", err)
+							}
+							if err != nil {
+								return fmt.Errorf("failed to import swarm key: %w", err)
+							}
+
+							fmt.Printf("Swarm key imported (fingerprint %s)\n", management.SwarmKeyFingerprint(psk))
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "file",
+								Aliases: []string{"i"},
+								Usage:   "swarm.key file to import. Read from stdin when omitted or -",
+							},
+							&cli.BoolFlag{
+								Name:    "force",
+								Aliases: []string{"f"},
+								Usage:   "Replace an existing swarm key",
+							},
+						},
+					},
+					{
+						Name:  "export",
+						Usage: "Print the swarm key, or write it to a file, to share it with another member",
+						Action: func(c *cli.Context) error {
+							data, err := management.ExportSwarmKey()
+							if err != nil {
+								return fmt.Errorf("failed to export swarm key: %w", err)
+							}
+
+							if c.String("file") == "" || c.String("file") == "-" {
+								fmt.Print(string(data))
+								return nil
+							}
+
+							if err := os.WriteFile(c.String("file"), data, 0600); err != nil {
+								return fmt.Errorf("failed to write swarm key: %w", err)
+							}
+							fmt.Printf("Swarm key written to %s\n", c.String("file"))
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "file",
+								Aliases: []string{"o"},
+								Usage:   "File to write the swarm key to. Printed to stdout when omitted or -",
+							},
+						},
+					},
+					{
+						Name:  "remove",
+						Usage: "Delete the swarm key and return to the public network",
+						Action: func(c *cli.Context) error {
+							if err := management.RemoveSwarmKey(); err != nil {
+								return fmt.Errorf("failed to remove swarm key: %w", err)
+							}
+							fmt.Println("Swarm key removed. This node will join the public network")
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "rooms",
+				Usage: "Browse the public rooms advertised by other Blue Otter peers",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List public rooms found through the DHT and on the local network",
+						Action: func(c *cli.Context) error {
+							if _, err := strconv.Atoi(c.String("port")); err != nil {
+								return cli.Exit("port must be a number", exitUsage)
+							}
+							addrs, err := listenAddrs(c)
+							if err != nil {
+								return cli.Exit(err.Error(), exitUsage)
+							}
+
+							ctx, cancel := context.WithCancel(context.Background())
+							defer cancel()
+
+							var events client.EventSink = client.EventSinkFunc(func(client.Event) {})
+							if c.Bool("verbose") {
+								events = client.NewWriterSink(os.Stderr)
+							}
+
+							session, err := client.StartServer(ctx, "Guest", addrs, c.Bool("lan"), client.DefaultMessageLimits(), common.DefaultClientResourceLimits(), 0, true, events)
+							if err != nil {
+								return startupExit(err)
+							}
+							defer session.Close()
+
+							browseCtx, browseCancel := context.WithTimeout(ctx, c.Duration("timeout"))
+							defer browseCancel()
+
+							// Nobody can be asked until we are connected to someone, a bootstrap node or a LAN peer
+							ticker := time.NewTicker(250 * time.Millisecond)
+							defer ticker.Stop()
+							for len(session.Host.Network().Peers()) == 0 {
+								select {
+								case <-browseCtx.Done():
+									return cli.Exit(fmt.Sprintf("no peers reachable within %s", c.Duration("timeout")), exitNoPeers)
+								case <-ticker.C:
+								}
+							}
+
+							rooms, err := session.BrowseRooms(browseCtx)
+							if err != nil {
+								return cli.Exit(fmt.Sprintf("failed to browse rooms: %s", err), exitFailure)
+							}
+
+							if len(rooms) == 0 {
+								fmt.Println("No public rooms found")
+								return nil
+							}
+							fmt.Println("Public rooms:")
+							for i, room := range rooms {
+								fmt.Printf("%d. %s\n", i+1, describePublicRoom(room))
+							}
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "port",
+								Aliases: []string{"p"},
+								Usage:   "Port to listen on while browsing (0 picks a free port)",
+								Value:   "0",
+							},
+							&cli.StringSliceFlag{
+								Name:  "listen",
+								Usage: "Multiaddr to listen on, may be repeated (overrides --port, default: TCP, WebSocket, QUIC and WebTransport over IPv4 and IPv6)",
+							},
+							&cli.BoolFlag{
+								Name:  "lan",
+								Usage: "Discover peers on the local network with mDNS, no bootstrap node needed",
+							},
+							&cli.DurationFlag{
+								Name:    "timeout",
+								Aliases: []string{"t"},
+								Usage:   "How long to spend looking for rooms",
+								Value:   30 * time.Second,
+							},
+							&cli.BoolFlag{
+								Name:  "verbose",
+								Usage: "Log networking progress to stderr",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:    "invite",
+				Aliases: []string{"inv"},
+				Usage:   "Manage invitations to invite-only rooms",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "create",
+						Usage: "Create an invitation to an invite-only room owned by you, creating the room with the first invitation",
+						Action: func(c *cli.Context) error {
+							if c.String("room") == "" {
+								return fmt.Errorf("no room specified. use --room or -r flag")
+							}
+							if c.Duration("expires") <= 0 {
+								return fmt.Errorf("--expires must be positive")
+							}
+							roomName := client.NormalizeRoomName(c.String("room"))
+
+							inv, created, err := client.CreateInvitation(roomName, c.Duration("expires"))
+							if err != nil {
+								return fmt.Errorf("failed to create invitation: %w", err)
+							}
+
+							token, err := inv.Encode()
+							if err != nil {
+								return fmt.Errorf("failed to create invitation: %w", err)
+							}
+
+							if created {
+								fmt.Printf("%s is now invite-only and owned by you. Messages from anyone without an invitation are dropped\n", inv.Room)
+							}
+							fmt.Printf("Invitation to %s, expiring %s:\n\n%s\n\n", inv.Room, inv.ExpiresAt().Format("Jan 2 15:04"), token)
+							fmt.Println("Join with: blue-otter client --invite <token>. The token carries the room key, the only proof of membership, so anyone holding it can join even after it expires and access cannot be revoked short of creating a new room. Share it privately")
+							return nil
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "room",
+								Aliases: []string{"r"},
+								Usage:   "Room to invite to",
+							},
+							&cli.DurationFlag{
+								Name:    "expires",
+								Aliases: []string{"e"},
+								Usage:   "How long the invitation can be redeemed for",
+								Value:   24 * time.Hour,
+							},
+						},
+					},
+				},
+			},
 			{
 				Name:    "clean-up",
 				Aliases: []string{"cu"},
@@ -426,3 +1601,119 @@ BOOTSTRAP NODE - P2P Network Entry Point - v0.1.0
 		os.Exit(1)
 	}
 }
+
+// listenAddrs returns the addresses given with --listen, or the addresses of every supported transport on --port
+func listenAddrs(c *cli.Context) ([]string, error) {
+	addrs := c.StringSlice("listen")
+	if len(addrs) == 0 {
+		addrs = common.DefaultListenAddrs(c.String("port"))
+	}
+	if err := common.ValidateListenAddrs(addrs); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// messageLimits reads the room message limits from the command line flags
+func messageLimits(c *cli.Context) (client.MessageLimits, error) {
+	limits := client.MessageLimits{
+		MaxSize: c.Int("max-message-size"),
+		Rate:    c.Float64("max-message-rate"),
+		Burst:   c.Int("max-message-burst"),
+	}
+	return limits, limits.Validate()
+}
+
+// startupExit turns an error starting a node into an exit error, with an exit code and a hint for each known cause
+func startupExit(err error) error {
+	switch {
+	case errors.Is(err, common.ErrPortInUse):
+		return cli.Exit(fmt.Sprintf("%s\nAnother process is listening on the same port, pick another one with --port or --listen", err), exitPortInUse)
+	case errors.Is(err, common.ErrKeyCorrupt):
+		return cli.Exit(fmt.Sprintf("%s\nRestore the file from a backup, or remove it to start over with a new key", err), exitKeyCorrupt)
+	case errors.Is(err, common.ErrDHTFailed):
+		return cli.Exit(err.Error(), exitDHTFailed)
+	}
+	return cli.Exit(fmt.Sprintf("failed to start: %s", err), exitFailure)
+}
+
+// resourceFlags returns the connection watermark and resource limit flags, defaulting to defaults
+func resourceFlags(defaults common.ResourceLimits) []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "conn-low",
+			Usage: "Connections kept when trimming, bootstrap and room mesh peers are never trimmed",
+			Value: defaults.LowWater,
+		},
+		&cli.IntFlag{
+			Name:  "conn-high",
+			Usage: "Connections that start trimming back down to --conn-low",
+			Value: defaults.HighWater,
+		},
+		&cli.DurationFlag{
+			Name:  "conn-grace",
+			Usage: "How long a new connection is safe from trimming",
+			Value: defaults.GracePeriod,
+		},
+		&cli.Int64Flag{
+			Name:  "max-memory",
+			Usage: "Memory in MiB the resource limits are scaled to (0: an eighth of system memory)",
+			Value: defaults.MaxMemory,
+		},
+		&cli.IntFlag{
+			Name:  "max-fds",
+			Usage: "File descriptors connections may use (0: half of the process limit)",
+			Value: defaults.MaxFDs,
+		},
+	}
+}
+
+// resourceLimits reads the connection watermarks and resource limits from the command line flags
+func resourceLimits(c *cli.Context) (common.ResourceLimits, error) {
+	limits := common.ResourceLimits{
+		LowWater:    c.Int("conn-low"),
+		HighWater:   c.Int("conn-high"),
+		GracePeriod: c.Duration("conn-grace"),
+		MaxMemory:   c.Int64("max-memory"),
+		MaxFDs:      c.Int("max-fds"),
+	}
+	return limits, limits.Validate()
+}
+
+// acceptInvitation redeems the token given with --invite and returns the room it is for
+func acceptInvitation(c *cli.Context) (string, error) {
+	if c.String("room-secret") != "" {
+		return "", fmt.Errorf("--invite cannot be combined with --room-secret, the invitation carries the room key")
+	}
+
+	inv, err := client.AcceptInvitation(c.String("invite"), c.Bool("replace-key"))
+	if errors.Is(err, client.ErrInviteKeyExists) {
+		return "", fmt.Errorf("failed to accept invitation: %w (pass --replace-key if you trust this invitation over the one you accepted before)", err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to accept invitation: %w", err)
+	}
+
+	fmt.Printf("Invited to %s by %s, the invitation is valid until %s\n", inv.Room, inv.Inviter, inv.ExpiresAt().Format("Jan 2 15:04"))
+	return inv.Room, nil
+}
+
+// describePublicRoom formats a room found in the room directory
+func describePublicRoom(room client.PublicRoom) string {
+	line := fmt.Sprintf("%s - %d members", room.Name, room.Members)
+	if room.Members == 1 {
+		line = fmt.Sprintf("%s - 1 member", room.Name)
+	}
+	if !room.Created.IsZero() {
+		line += fmt.Sprintf(", created %s", room.Created.Format("Jan 2 2006 15:04"))
+	}
+	return line
+}
+
+// untilSuffix describes when a ban or mute expires, or nothing when it is permanent
+func untilSuffix(until time.Time) string {
+	if until.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(" until %s", until.Format("Jan 2 15:04"))
+}
@@ -15,7 +15,10 @@ import (
 	peer "github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	autonat "github.com/libp2p/go-libp2p/p2p/host/autonat"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	gater "github.com/patrickma6199/blue-otter/internal/blue_otter_gater"
 	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+	store "github.com/patrickma6199/blue-otter/internal/blue_otter_store"
 )
 
 // SetupConnectionNotifications (non-tui version) configures the host to log connection events
@@ -34,18 +37,64 @@ func SetupConnectionNotifications(host host.Host) {
 	})
 }
 
-func StartBootstrapNode(ctx context.Context, port string, quitCh <-chan struct{}) (host.Host, error) {
+// StartBootstrapNode brings up a DHT server on listenAddrs, trimming connections and bounding resources with resources
+func StartBootstrapNode(ctx context.Context, listenAddrs []string, relayConfig RelayConfig, resources *common.HostResources, quitCh <-chan struct{}) (host.Host, error) {
+	// A corrupt identity must not be silently replaced, every client has the address of this node saved
 	savedPrivKey, err := management.GetPrivateKey()
 	if err != nil {
-		log.Printf("[Networking] Warning: Failed to load private key: %v. Will create new identity.", err)
+		return nil, fmt.Errorf("[Networking] Failed to load private key: %w", err)
 	}
 
+	// A corrupt swarm key must not silently fall back to the public network
+	psk, err := management.LoadSwarmKey()
+	if err != nil {
+		return nil, fmt.Errorf("[Networking] Failed to load swarm key: %w", err)
+	}
+	private := psk != nil
+
 	var options []libp2p.Option
 
+	if private {
+		usable, dropped := common.PrivateNetworkListenAddrs(listenAddrs)
+		for _, addr := range dropped {
+			log.Printf("[Networking] Not listening on %s: private networks only support TCP, and WebSocket on a port of its own", addr)
+		}
+		log.Printf("[Networking] Private network enabled, swarm key fingerprint %s", management.SwarmKeyFingerprint(psk))
+		options = append(options,
+			libp2p.ListenAddrStrings(usable...),
+			libp2p.PrivateNetwork(psk),
+		)
+	} else {
+		options = append(options,
+			libp2p.ListenAddrStrings(listenAddrs...),
+			libp2p.ShareTCPListener(),
+		)
+	}
+
+	connGater, err := gater.Load()
+	if err != nil {
+		return nil, fmt.Errorf("[Networking] Failed to load access lists: %w", err)
+	}
+
+	// Remembered peers and DHT records make restarts reconnect and serve records straight away
+	peerStore, err := store.Open(ctx)
+	if err != nil {
+		log.Printf("[Networking] Warning: %v. Known peers and DHT records will not be kept across restarts.", err)
+		if peerStore, err = store.OpenMemory(ctx); err != nil {
+			return nil, fmt.Errorf("[Networking] Failed to create peerstore: %w", err)
+		}
+	}
+
 	options = append(options,
-		libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/"+port),
+		libp2p.ConnectionGater(connGater),
 		libp2p.EnableHolePunching(),
 	)
+	options = append(options, resources.Options()...)
+	options = append(options, peerStore.Options()...)
+
+	if err := resources.CheckLimits(); err != nil {
+		log.Printf("[Networking] Warning: %v, raise --max-memory or lower --conn-high", err)
+	}
 
 	if savedPrivKey != nil {
 		log.Println("[Networking] Using saved identity for node")
@@ -56,7 +105,8 @@ func StartBootstrapNode(ctx context.Context, port string, quitCh <-chan struct{}
 
 	host, err := libp2p.New(options...)
 	if err != nil {
-		return nil, fmt.Errorf("[Networking] Failed to create libp2p host: %w", err)
+		peerStore.Close()
+		return nil, fmt.Errorf("[Networking] Failed to create libp2p host: %w", common.ListenError(err))
 	}
 
 	SetupConnectionNotifications(host)
@@ -66,6 +116,13 @@ func StartBootstrapNode(ctx context.Context, port string, quitCh <-chan struct{}
 		log.Printf("[Networking] AutoNAT warning: %v\n", err)
 	}
 
+	if relayConfig.Enabled {
+		if err := startRelayService(ctx, host, relayConfig); err != nil {
+			host.Close()
+			return nil, err
+		}
+	}
+
 	fmt.Println("[Networking] Bootstrap Node Started")
 	fmt.Println("[Networking] Peer ID:", host.ID())
 	fmt.Println("Listening on:")
@@ -73,56 +130,86 @@ func StartBootstrapNode(ctx context.Context, port string, quitCh <-chan struct{}
 		fmt.Printf(" - %s/p2p/%s\n", addr, host.ID())
 	}
 
-	kDht, err := dht.New(ctx, host, dht.Mode(dht.ModeServer), dht.ProtocolPrefix("/ipfs/blue-otter"))
+	dhtOptions := append([]dht.Option{dht.Mode(dht.ModeServer), dht.ProtocolPrefix(common.DHTProtocolPrefix)}, peerStore.DHTOptions()...)
+	kDht, err := dht.New(ctx, host, dhtOptions...)
 	if err != nil {
-		return nil, fmt.Errorf("[Networking] Failed to create DHT: %w", err)
+		host.Close()
+		return nil, fmt.Errorf("[Networking] %w: %v", common.ErrDHTFailed, err)
 	}
 
 	if err := kDht.Bootstrap(ctx); err != nil {
-		return nil, fmt.Errorf("[Networking] Failed to bootstrap DHT: %w", err)
+		kDht.Close()
+		host.Close()
+		return nil, fmt.Errorf("[Networking] %w: %v", common.ErrDHTFailed, err)
+	}
+
+	if peerStore.Persistent {
+		if reached := peerStore.Reconnect(ctx, host); reached > 0 {
+			fmt.Printf("[Networking] Reconnected to %d known peers\n", reached)
+		}
+		go peerStore.Remember(ctx, host)
 	}
 
 	disc := routing.NewRoutingDiscovery(kDht)
 
+	// Clients come to us, so only a few peers found under the global namespace are dialed to keep the DHT healthy
 	go func() {
 		deadPeers := make(map[peer.ID]time.Time)
+		discovered := make(map[peer.ID]bool)
 		for {
-			_, err := disc.Advertise(ctx, "--blue-otter-namespace--")
+			_, err := disc.Advertise(ctx, common.GlobalNamespace)
 			if err != nil {
 				if err.Error() != "failed to find any peer in table" {
 					fmt.Println("[Discovery] Error advertising:", err)
 				}
 			}
 
-			peerChan, err := disc.FindPeers(ctx, "--blue-otter-namespace--")
-			if err != nil {
-				if err.Error() != "failed to find any peer in table" {
-					fmt.Println("[Discovery] Error finding peers:", err)
+			for id := range discovered {
+				if host.Network().Connectedness(id) != network.Connected {
+					delete(discovered, id)
 				}
-				continue
 			}
 
-			for p := range peerChan {
-				if p.ID == host.ID() || len(p.Addrs) == 0 {
-					continue
-				}
-
-				if nextRetry, found := deadPeers[p.ID]; found && time.Now().Before(nextRetry) {
-					continue
-				}
-
-				if host.Network().Connectedness(p.ID) != network.Connected {
-					fmt.Println("[Discovery] Connecting to peer:", p.ID)
-					if err := host.Connect(ctx, p); err != nil {
-						fmt.Println("[Discovery] Failed to connect to peer:", err)
-						deadPeers[p.ID] = time.Now().Add(1 * time.Minute)
-					} else {
-						delete(deadPeers, p.ID)
+			if len(discovered) < common.GlobalPeerLimit {
+				findCtx, cancel := context.WithCancel(ctx)
+				peerChan, err := disc.FindPeers(findCtx, common.GlobalNamespace)
+				if err != nil {
+					if err.Error() != "failed to find any peer in table" {
+						fmt.Println("[Discovery] Error finding peers:", err)
+					}
+				} else {
+					for p := range peerChan {
+						if len(discovered) >= common.GlobalPeerLimit {
+							break
+						}
+						if p.ID == host.ID() || len(p.Addrs) == 0 {
+							continue
+						}
+
+						if nextRetry, found := deadPeers[p.ID]; found && time.Now().Before(nextRetry) {
+							continue
+						}
+
+						if host.Network().Connectedness(p.ID) != network.Connected {
+							fmt.Println("[Discovery] Connecting to peer:", p.ID)
+							if err := host.Connect(ctx, p); err != nil {
+								fmt.Println("[Discovery] Failed to connect to peer:", management.ExplainHandshakeError(err, private))
+								deadPeers[p.ID] = time.Now().Add(1 * time.Minute)
+								continue
+							}
+							delete(deadPeers, p.ID)
+						}
+						discovered[p.ID] = true
 					}
 				}
+				cancel()
 			}
 
-			time.Sleep(5 * time.Second)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(30 * time.Second):
+			}
 		}
 	}()
 
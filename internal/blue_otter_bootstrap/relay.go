@@ -0,0 +1,75 @@
+package blue_otter_bootstrap
+
+// relay.go contains all functions related to the circuit relay v2 service offered by bootstrap nodes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+)
+
+// RelayConfig controls the circuit relay v2 service that lets NAT-ed clients reach each other through a bootstrap node
+type RelayConfig struct {
+	Enabled bool
+	// MaxReservations is the number of clients that may hold a relay slot at once
+	MaxReservations int
+	// MaxCircuits is the number of relayed connections each client may have open at once
+	MaxCircuits int
+	// ReservationTTL is how long a reservation lasts before the client has to refresh it
+	ReservationTTL time.Duration
+	// MaxDuration is how long a single relayed connection may stay open
+	MaxDuration time.Duration
+	// MaxData is how many bytes a single relayed connection may carry in each direction
+	MaxData int64
+}
+
+// DefaultRelayConfig returns the relay limits recommended by libp2p, with the service disabled
+func DefaultRelayConfig() RelayConfig {
+	rc := relayv2.DefaultResources()
+	return RelayConfig{
+		MaxReservations: rc.MaxReservations,
+		MaxCircuits:     rc.MaxCircuits,
+		ReservationTTL:  rc.ReservationTTL,
+		MaxDuration:     rc.Limit.Duration,
+		MaxData:         rc.Limit.Data,
+	}
+}
+
+func (cfg RelayConfig) resources() relayv2.Resources {
+	rc := relayv2.DefaultResources()
+	rc.MaxReservations = cfg.MaxReservations
+	rc.MaxCircuits = cfg.MaxCircuits
+	rc.ReservationTTL = cfg.ReservationTTL
+	rc.Limit = &relayv2.RelayLimit{
+		Duration: cfg.MaxDuration,
+		Data:     cfg.MaxData,
+	}
+	return rc
+}
+
+// startRelayService starts the relay v2 service on host until ctx is done.
+// The service is started directly rather than through libp2p.EnableRelayService, which waits for
+// AutoNAT to report public reachability: bootstrap nodes are public by definition.
+func startRelayService(ctx context.Context, host host.Host, cfg RelayConfig) error {
+	if cfg.MaxReservations <= 0 || cfg.MaxCircuits <= 0 || cfg.ReservationTTL <= 0 || cfg.MaxDuration <= 0 || cfg.MaxData <= 0 {
+		return fmt.Errorf("[Relay] Relay limits must be positive")
+	}
+
+	relay, err := relayv2.New(host, relayv2.WithResources(cfg.resources()))
+	if err != nil {
+		return fmt.Errorf("[Relay] Failed to start relay service: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		relay.Close()
+	}()
+
+	fmt.Printf("[Relay] Relay service started: %d reservations, %d circuits per peer, %s / %d bytes per circuit\n",
+		cfg.MaxReservations, cfg.MaxCircuits, cfg.MaxDuration, cfg.MaxData)
+
+	return nil
+}
@@ -4,14 +4,12 @@ package blue_otter_client
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
-	"time"
 
 	libp2p "github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	peer "github.com/libp2p/go-libp2p/core/peer"
@@ -19,213 +17,324 @@ import (
 	autonat "github.com/libp2p/go-libp2p/p2p/host/autonat"
 	multiaddr "github.com/multiformats/go-multiaddr"
 	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	gater "github.com/patrickma6199/blue-otter/internal/blue_otter_gater"
 	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
-	"github.com/rivo/tview"
+	store "github.com/patrickma6199/blue-otter/internal/blue_otter_store"
 )
 
-// SetupConnectionNotifications configures the host to log connection events
-func SetupConnectionNotifications(host host.Host, systemLogView *tview.TextView) {
+// SetupConnectionNotifications configures the host to report connection events
+func SetupConnectionNotifications(host host.Host, events EventSink) {
 	host.Network().Notify(&network.NotifyBundle{
 		ConnectedF: func(n network.Network, conn network.Conn) {
-			remotePeer := conn.RemotePeer()
-			remoteAddr := conn.RemoteMultiaddr()
-			systemLogView.Write([]byte(fmt.Sprintf("[Networking] Connected to peer: %s via %s\n", remotePeer.String(), remoteAddr)))
+			events.HandleEvent(ConnectionEvent{PeerID: conn.RemotePeer(), Addr: conn.RemoteMultiaddr().String(), Connected: true})
 		},
 		DisconnectedF: func(n network.Network, conn network.Conn) {
-			remotePeer := conn.RemotePeer()
-			remoteAddr := conn.RemoteMultiaddr()
-			systemLogView.Write([]byte(fmt.Sprintf("[Networking] Disconnected from peer: %s via %s\n", remotePeer.String(), remoteAddr)))
+			events.HandleEvent(ConnectionEvent{PeerID: conn.RemotePeer(), Addr: conn.RemoteMultiaddr().String(), Connected: false})
 		},
 	})
 }
 
-func StartServer(ctx context.Context, username string, roomName string, port string, quitCh <-chan struct{}, chatView *tview.TextView, systemLogView *tview.TextView) (host.Host, *pubsub.Subscription, *pubsub.Topic) {
-	host := networkConfiguration(ctx, port, systemLogView)
-
-	SetupConnectionNotifications(host, systemLogView)
-
-	sub, topic := pubSubConfiguration(ctx, host, roomName)
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				msg, err := sub.Next(ctx)
-				if err != nil {
-					return
-				}
-
-				var chatMsg common.ChatMessage
-				err = json.Unmarshal(msg.Data, &chatMsg)
-				if err != nil {
-					var sysMsg common.SystemNotification
-					err = json.Unmarshal(msg.Data, &sysMsg)
-					if err == nil {
-						systemLogView.Write([]byte(fmt.Sprintf("[%s | notification] %s\n", roomName, sysMsg.Message)))
-					} else {
-						chatView.Write([]byte(fmt.Sprintf("[%s] <%s> (unparsed): %s\n", roomName, msg.ReceivedFrom, string(msg.Data))))
-					}
-					continue
-				}
-
-				if(chatMsg.Sender != "" && chatMsg.Text != "") {
-					chatView.Write([]byte(fmt.Sprintf("[%s] <%s>: %s\n", roomName, chatMsg.Sender, chatMsg.Text)))
-				}
-			}
+// PublishMessage wraps payload in an envelope of the given kind and publishes it to the topic,
+// encrypting it first when the room has a key
+func PublishMessage(ctx context.Context, host host.Host, topic *pubsub.Topic, roomKey *common.RoomKey, kind string, payload any) error {
+	env, err := common.NewEnvelope(kind, host.ID().String(), payload)
+	if err != nil {
+		return err
+	}
+
+	if err := env.Sign(host.Peerstore().PrivKey(host.ID())); err != nil {
+		return err
+	}
+
+	if roomKey != nil {
+		if env, err = roomKey.Seal(env); err != nil {
+			return err
 		}
-	}()
+	}
+
+	data, err := common.EncodeEnvelope(env)
+	if err != nil {
+		return fmt.Errorf("failed to encode envelope: %w", err)
+	}
+
+	return topic.Publish(ctx, data)
+}
+
+// StartServer brings up the host, DHT and GossipSub router for a session, listening on listenAddrs.
+// With lan set, peers on the local network are also discovered with mDNS. Room messages breaking limits are rejected,
+// and connections beyond the watermarks in resources are trimmed. Rooms are joined afterwards with Session.Join.
+// When startup fails everything created so far is closed again, and the error wraps common.ErrPortInUse,
+// common.ErrKeyCorrupt or common.ErrDHTFailed when it has one of those causes.
+//
+// An ephemeral session, as used by one-shot commands, runs under a throwaway identity with its peers kept in memory.
+// It neither clashes with a client or daemon running under the saved identity, whose GossipSub router would drop
+// messages appearing to come from itself, nor changes anything under ~/.blue-otter.
+func StartServer(ctx context.Context, username string, listenAddrs []string, lan bool, limits MessageLimits, resources common.ResourceLimits, historySize int, ephemeral bool, events EventSink) (*Session, error) {
+	identities := newIdentityBook()
+	directMessenger := newDirectMessenger(identities, events)
+
+	// A corrupt access list must not silently let blocked peers back in
+	connGater, err := gater.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	hostResources, err := common.NewHostResources(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	// Background work started for the session stops with this context when a later step fails
+	ctx, cancel := context.WithCancel(ctx)
+
+	host, kDht, disc, err := networkConfiguration(ctx, listenAddrs, lan, ephemeral, connGater, hostResources, directMessenger, events)
+	if err != nil {
+		cancel()
+		hostResources.Close()
+		return nil, err
+	}
+
+	SetupConnectionNotifications(host, events)
+
+	ps, err := pubSubConfiguration(ctx, host, limits, events)
+	if err != nil {
+		cancel()
+		kDht.Close()
+		host.Close()
+		return nil, err
+	}
 
-	return host, sub, topic
+	session := &Session{
+		ctx:             ctx,
+		cancel:          cancel,
+		Host:            host,
+		DirectMessenger: directMessenger,
+		Gater:           connGater,
+		username:        username,
+		ps:              ps,
+		dht:             kDht,
+		discovery:       disc,
+		resources:       hostResources,
+		identities:      identities,
+		events:          events,
+		historySize:     historySize,
+		status:          StatusOnline,
+		rooms:           make(map[string]*Room),
+	}
+
+	host.SetStreamHandler(HistorySyncProtocol, session.handleHistoryRequest)
+	host.SetStreamHandler(ModerationSyncProtocol, session.handleModerationRequest)
+	host.SetStreamHandler(RoomDirectoryProtocol, session.handleDirectoryRequest)
+
+	return session, nil
 }
 
-func networkConfiguration(ctx context.Context, port string, systemLogView *tview.TextView) host.Host {
+func networkConfiguration(ctx context.Context, listenAddrs []string, lan bool, ephemeral bool, connGater *gater.Gater, resources *common.HostResources, directMessenger *DirectMessenger, events EventSink) (host.Host, *dht.IpfsDHT, *peerDiscovery, error) {
 	// ---------------------- Network Connection Configuration ----------------------
 
-	savedPrivKey, err := management.GetPrivateKey()
+	// A corrupt identity must not be silently replaced, peers know us by it
+	var savedPrivKey crypto.PrivKey
+	if !ephemeral {
+		privKey, err := management.GetPrivateKey()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		savedPrivKey = privKey
+	}
+
+	bootstrapPeers := loadBootstrapPeers(events)
+
+	// A corrupt swarm key must not silently fall back to the public network
+	psk, err := management.LoadSwarmKey()
 	if err != nil {
-		systemLogView.Write([]byte(fmt.Sprintf("[Networking] Warning: Failed to load private key: %v. Will create new identity.\n", err)))
+		return nil, nil, nil, err
 	}
+	private := psk != nil
 
 	var options []libp2p.Option
 
+	if private {
+		usable, dropped := common.PrivateNetworkListenAddrs(listenAddrs)
+		for _, addr := range dropped {
+			events.HandleEvent(logEvent("Networking", "Not listening on %s: private networks only support TCP, and WebSocket on a port of its own", addr))
+		}
+		events.HandleEvent(logEvent("Networking", "Private network enabled, swarm key fingerprint %s", management.SwarmKeyFingerprint(psk)))
+		options = append(options,
+			libp2p.ListenAddrStrings(usable...),
+			libp2p.PrivateNetwork(psk),
+		)
+	} else {
+		options = append(options,
+			libp2p.ListenAddrStrings(listenAddrs...),
+			libp2p.ShareTCPListener(),
+		)
+	}
+
 	options = append(options,
-		libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/"+port),
+		libp2p.ConnectionGater(connGater),
 		libp2p.EnableHolePunching(),
 	)
+	options = append(options, resources.Options()...)
 
-	if savedPrivKey != nil {
-		systemLogView.Write([]byte("[Networking] Using saved identity for node\n"))
+	// Remembered peers and DHT records make restarts reconnect without waiting for the bootstrap nodes
+	var peerStore *store.Store
+	if !ephemeral {
+		if peerStore, err = store.Open(ctx); err != nil {
+			events.HandleEvent(logEvent("Networking", "Warning: %v. Known peers will not be remembered across restarts.", err))
+		}
+	}
+	if peerStore == nil {
+		if peerStore, err = store.OpenMemory(ctx); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	options = append(options, peerStore.Options()...)
+
+	if err := resources.CheckLimits(); err != nil {
+		events.HandleEvent(logEvent("Networking", "Warning: %v, raise --max-memory or lower --conn-high", err))
+	}
+
+	// Bootstrap nodes running a relay service give NAT-ed peers a rendezvous path for hole punching
+	if len(bootstrapPeers) > 0 {
+		options = append(options, libp2p.EnableAutoRelayWithStaticRelays(bootstrapPeers))
+	}
+
+	if ephemeral {
+		events.HandleEvent(logEvent("Networking", "Using a throwaway identity for this command"))
+	} else if savedPrivKey != nil {
+		events.HandleEvent(logEvent("Networking", "Using saved identity for node"))
 		options = append(options, libp2p.Identity(savedPrivKey))
 	} else {
-		systemLogView.Write([]byte("[Networking] Creating new identity for node\n"))
+		events.HandleEvent(logEvent("Networking", "Creating new identity for node"))
 	}
 
 	host, err := libp2p.New(options...)
 	if err != nil {
-		log.Fatal(err)
+		peerStore.Close()
+		return nil, nil, nil, fmt.Errorf("failed to create libp2p host: %w", common.ListenError(err))
 	}
-	systemLogView.Write([]byte(fmt.Sprintf("[Networking] Host created. We are %s\n", host.ID())))
+	events.HandleEvent(logEvent("Networking", "Host created. We are %s", host.ID()))
+
+	directMessenger.attach(host)
 
 	_, err = autonat.New(host)
 	if err != nil {
-		systemLogView.Write([]byte(fmt.Sprintf("[Networking] AutoNAT warning: %v\n", err)))
+		events.HandleEvent(logEvent("Networking", "AutoNAT warning: %v", err))
 	}
 
-	systemLogView.Write([]byte(fmt.Sprintf("[Networking] My Peer ID: %s\n", host.ID())))
+	events.HandleEvent(logEvent("Networking", "My Peer ID: %s", host.ID()))
 	for _, addr := range host.Addrs() {
-		systemLogView.Write([]byte(fmt.Sprintf("[Networking] Listening on: %s/p2p/%s\n", addr, host.ID())))
+		events.HandleEvent(logEvent("Networking", "Listening on: %s/p2p/%s", addr, host.ID()))
 	}
 
-	kDht, err := dht.New(ctx, host, dht.Mode(dht.ModeClient), dht.ProtocolPrefix("/ipfs/blue-otter"))
+	dhtOptions := append([]dht.Option{dht.Mode(dht.ModeClient), dht.ProtocolPrefix(common.DHTProtocolPrefix)}, peerStore.DHTOptions()...)
+	kDht, err := dht.New(ctx, host, dhtOptions...)
 	if err != nil {
-		log.Fatal(err)
+		host.Close()
+		return nil, nil, nil, fmt.Errorf("%w: %v", common.ErrDHTFailed, err)
 	}
 	if err := kDht.Bootstrap(ctx); err != nil {
-		log.Fatal(err)
+		kDht.Close()
+		host.Close()
+		return nil, nil, nil, fmt.Errorf("%w: %v", common.ErrDHTFailed, err)
+	}
+
+	// Room mesh peers are protected by GossipSub itself, tagged with their topic
+	for _, info := range bootstrapPeers {
+		host.ConnManager().Protect(info.ID, common.BootstrapPeerTag)
+		if err := host.Connect(ctx, info); err == nil {
+			events.HandleEvent(logEvent("Networking", "Connected to bootstrap: %s", info.String()))
+		} else {
+			events.HandleEvent(logEvent("Networking", "Failed to connect to bootstrap peer %s: %v", info.ID, management.ExplainHandshakeError(err, private)))
+		}
+	}
+
+	// Bootstrap nodes that are down or drop later are redialed, and the status shown to the user follows
+	go newSupervisor(host, kDht, bootstrapPeers, private, events).run(ctx)
+
+	if peerStore.Persistent {
+		if reached := peerStore.Reconnect(ctx, host); reached > 0 {
+			events.HandleEvent(logEvent("Networking", "Reconnected to %d known peers", reached))
+		}
+		go peerStore.Remember(ctx, host)
 	}
 
+	if lan {
+		if err := startLANDiscovery(ctx, host, private, events); err != nil {
+			events.HandleEvent(logEvent("Discovery", "Warning: LAN discovery unavailable: %v", err))
+		} else {
+			events.HandleEvent(logEvent("Discovery", "LAN discovery enabled, looking for peers on the local network"))
+		}
+	}
+
+	// Room members are found per room once rooms are joined, the global namespace only keeps the DHT healthy
+	disc := newPeerDiscovery(host, routing.NewRoutingDiscovery(kDht), private, events)
+	go disc.discoverGlobal(ctx)
+
+	if !ephemeral {
+		if err := management.SaveAddressInfo(host); err != nil {
+			events.HandleEvent(logEvent("Config", "Warning: Failed to save bootstrap info: %v", err))
+		}
+	}
+
+	return host, kDht, disc, nil
+}
+
+// loadBootstrapPeers parses the saved bootstrap addresses, skipping any that are invalid
+func loadBootstrapPeers(events EventSink) []peer.AddrInfo {
 	bootstrapAddrs, err := management.LoadBootstrapAddressesForConnections()
 	if err != nil {
-		systemLogView.Write([]byte(fmt.Sprintf("[Networking] Warning: Failed to load bootstrap addresses: %v\n", err)))
+		events.HandleEvent(logEvent("Networking", "Warning: Failed to load bootstrap addresses: %v", err))
 		bootstrapAddrs = []string{}
 	}
 
 	if len(bootstrapAddrs) == 0 {
-		bootstrapAddrs = []string{}
-		systemLogView.Write([]byte(fmt.Sprintf("[Networking] No bootstrap peers found. Please add some using the management commands.\n")))
-	} else {
-		systemLogView.Write([]byte(fmt.Sprintf("[Networking] Loaded %d bootstrap peers\n", len(bootstrapAddrs))))
+		events.HandleEvent(logEvent("Networking", "No bootstrap peers found. Please add some using the management commands."))
+		return nil
 	}
+	events.HandleEvent(logEvent("Networking", "Loaded %d bootstrap peers", len(bootstrapAddrs)))
 
+	// Several addresses of the same bootstrap node are merged, autorelay expects one entry per peer
+	var peers []peer.AddrInfo
+	merged := make(map[peer.ID]int)
 	for _, ba := range bootstrapAddrs {
 		maddr, err := multiaddr.NewMultiaddr(ba)
 		if err != nil {
-			systemLogView.Write([]byte(fmt.Sprintf("[Networking] Invalid bootstrap address: %s, error: %v\n", ba, err)))
+			events.HandleEvent(logEvent("Networking", "Invalid bootstrap address: %s, error: %v", ba, err))
 			continue
 		}
 		info, err := peer.AddrInfoFromP2pAddr(maddr)
 		if err != nil {
-			systemLogView.Write([]byte(fmt.Sprintf("[Networking] Failed to get peer info from address: %s, error: %v\n", ba, err)))
+			events.HandleEvent(logEvent("Networking", "Failed to get peer info from address: %s, error: %v", ba, err))
 			continue
 		}
-		if err := host.Connect(ctx, *info); err == nil {
-			systemLogView.Write([]byte(fmt.Sprintf("[Networking] Connected to bootstrap: %s\n", info.String())))
-		} else {
-			systemLogView.Write([]byte(fmt.Sprintf("[Networking] Failed to connect to bootstrap peer %s: %v\n", info.ID, err)))
-		}
-	}
-
-	disc := routing.NewRoutingDiscovery(kDht)
-	
-	go func() {
-		deadPeers := make(map[peer.ID]time.Time)
-
-		for {
-			_, err := disc.Advertise(ctx, "--blue-otter-namespace--")
-			if err != nil {
-				if err.Error() != "failed to find any peer in table" {
-					systemLogView.Write([]byte(fmt.Sprintf("[Discovery] Error advertising: %v\n", err)))
-				}
-			}
-
-			peerChan, err := disc.FindPeers(ctx, "--blue-otter-namespace--")
-			if err != nil {
-				if err.Error() != "failed to find any peer in table" {
-					systemLogView.Write([]byte(fmt.Sprintf("[Discovery] Error finding peers: %v\n", err)))
-				}
-				continue
-			}
-
-			for p := range peerChan {
-				if p.ID == host.ID() || len(p.Addrs) == 0 {
-					continue
-				}
-				
-				if nextRetry, found := deadPeers[p.ID]; found && time.Now().Before(nextRetry) {
-					continue
-				}
-
-				if host.Network().Connectedness(p.ID) != network.Connected {
-					systemLogView.Write([]byte(fmt.Sprintf("[Discovery] Connecting to peer from peer list: %s\n", p.ID)))
-					if err := host.Connect(ctx, p); err != nil {
-						systemLogView.Write([]byte(fmt.Sprintf("[Discovery] Failed to connect to peer from peer list: %v\nRetrying in 20 minutes...\n", err)))
-						deadPeers[p.ID] = time.Now().Add(20 * time.Minute)
-					} else {
-						delete(deadPeers, p.ID)
-					}
-				}
-			}
-
-			time.Sleep(5 * time.Second)
+		if i, found := merged[info.ID]; found {
+			peers[i].Addrs = append(peers[i].Addrs, info.Addrs...)
+			continue
 		}
-	}()
-
-	if err := management.SaveAddressInfo(host); err != nil {
-		systemLogView.Write([]byte(fmt.Sprintf("[Config] Warning: Failed to save bootstrap info: %v\n", err)))
+		merged[info.ID] = len(peers)
+		peers = append(peers, *info)
 	}
 
-	return host
+	return peers
 }
 
-func pubSubConfiguration(ctx context.Context, host host.Host, roomName string) (*pubsub.Subscription, *pubsub.Topic) {
+func pubSubConfiguration(ctx context.Context, host host.Host, limits MessageLimits, events EventSink) (*pubsub.PubSub, error) {
 	// ---------------------- PubSub Configuration ----------------------
 
-	ps, err := pubsub.NewGossipSub(ctx, host)
-	if err != nil {
-		log.Fatal(err)
-	}
+	validator := newMessageValidator(host.ID(), limits, events)
+	scoreParams, scoreThresholds := peerScoreParams()
 
-	topic, err := ps.Join(roomName)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	sub, err := topic.Subscribe()
+	ps, err := pubsub.NewGossipSub(ctx, host,
+		pubsub.WithDefaultValidator(validator.Validate),
+		pubsub.WithPeerScore(scoreParams, scoreThresholds),
+		// Members publish their own messages to every room peer rather than only their mesh, so a member pruned for a
+		// few rejected messages is still heard until it is graylisted, it just stops relaying messages for others
+		pubsub.WithFloodPublish(true),
+	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to start GossipSub: %w", err)
 	}
 
-	return sub, topic
+	return ps, nil
 }
@@ -0,0 +1,212 @@
+package blue_otter_client
+
+// directory.go contains all functions related to advertising public rooms in the DHT and browsing the room directory
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// RoomDirectoryProtocol is the stream protocol used to ask a peer which public rooms it is in
+const RoomDirectoryProtocol = protocol.ID("/blue-otter/rooms/1.0.0")
+
+const (
+	// DirectoryNamespace is advertised by every peer in at least one public room, so browsers know whom to ask
+	DirectoryNamespace = "blue-otter-directory"
+	// directoryPeers caps how many peers are asked for their rooms when browsing the directory
+	directoryPeers = 32
+	// maxDirectoryRooms caps how many rooms a single peer may list
+	maxDirectoryRooms = 256
+	// directoryRequestTimeout bounds a single room list request
+	directoryRequestTimeout = 10 * time.Second
+	// routingTableWait is how long browsing waits for an empty routing table to fill before asking the DHT anyway
+	routingTableWait = 5 * time.Second
+)
+
+// PublicRoom is a room found in the room directory
+type PublicRoom struct {
+	Name string
+	// Members is the largest member count reported for the room
+	Members int
+	// Created is the earliest creation time reported for the room, zero when nobody knows it
+	Created time.Time
+	// Hosts is how many peers reported being in the room
+	Hosts int
+}
+
+// publicRooms describes the public rooms we are in. Encrypted and invite-only rooms are never listed.
+func (s *Session) publicRooms() []common.RoomInfo {
+	var infos []common.RoomInfo
+	for _, name := range s.RoomNames() {
+		room, found := s.Room(name)
+		if !found || room.Encrypted() {
+			continue
+		}
+
+		members := 0
+		for _, member := range room.Members() {
+			if !member.TimedOut {
+				members++
+			}
+		}
+
+		// Browsing keeps the earliest time reported, so a room is dated by its longest standing member
+		infos = append(infos, common.RoomInfo{Topic: room.Name, Members: members, Created: room.joined.UnixMilli()})
+	}
+	return infos
+}
+
+// handleDirectoryRequest lists our public rooms to a peer browsing the directory
+func (s *Session) handleDirectoryRequest(st network.Stream) {
+	defer st.Close()
+
+	st.SetDeadline(time.Now().Add(directoryRequestTimeout))
+
+	data, err := json.Marshal(s.publicRooms())
+	if err != nil {
+		st.Reset()
+		return
+	}
+	st.Write(data)
+}
+
+// requestRooms asks a single peer which public rooms it is in
+func (s *Session) requestRooms(ctx context.Context, info peer.AddrInfo) ([]common.RoomInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, directoryRequestTimeout)
+	defer cancel()
+
+	if s.Host.Network().Connectedness(info.ID) != network.Connected {
+		if err := s.Host.Connect(ctx, info); err != nil {
+			return nil, err
+		}
+	}
+
+	st, err := s.Host.NewStream(ctx, info.ID, RoomDirectoryProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	st.SetDeadline(time.Now().Add(directoryRequestTimeout))
+	st.CloseWrite()
+
+	var infos []common.RoomInfo
+	if err := json.NewDecoder(io.LimitReader(st, maxDirectoryRooms*512)).Decode(&infos); err != nil {
+		return nil, err
+	}
+	if len(infos) > maxDirectoryRooms {
+		infos = infos[:maxDirectoryRooms]
+	}
+	return infos, nil
+}
+
+// waitForRoutingTable waits until the DHT routing table holds a peer, for at most routingTableWait
+func (s *Session) waitForRoutingTable(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, routingTableWait)
+	defer cancel()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for s.dht.RoutingTable().Size() == 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// BrowseRooms lists the public rooms of peers advertising the directory in the DHT and of peers we are already
+// connected to, which covers the local network too. Rooms are sorted with the busiest first.
+func (s *Session) BrowseRooms(ctx context.Context) ([]PublicRoom, error) {
+	candidates := make(map[peer.ID]peer.AddrInfo)
+	for _, p := range s.Host.Network().Peers() {
+		if len(candidates) >= directoryPeers {
+			break
+		}
+		candidates[p] = peer.AddrInfo{ID: p}
+	}
+
+	// A node that has only just connected has an empty routing table and would find nobody
+	s.waitForRoutingTable(ctx)
+
+	found, err := dutil.FindPeers(ctx, s.discovery.routing, DirectoryNamespace, discovery.Limit(directoryPeers))
+	if err != nil && len(candidates) == 0 {
+		return nil, err
+	}
+	for _, info := range found {
+		if len(candidates) >= directoryPeers {
+			break
+		}
+		if info.ID != s.Host.ID() && len(info.Addrs) > 0 {
+			candidates[info.ID] = info
+		}
+	}
+
+	var mu sync.Mutex
+	rooms := make(map[string]*PublicRoom)
+	merge := func(infos []common.RoomInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, info := range infos {
+			if !strings.HasPrefix(info.Topic, RoomPrefix) {
+				continue
+			}
+			room, found := rooms[info.Topic]
+			if !found {
+				room = &PublicRoom{Name: info.Topic}
+				rooms[info.Topic] = room
+			}
+			room.Hosts++
+			if info.Members > room.Members {
+				room.Members = info.Members
+			}
+			if info.Created > 0 {
+				created := time.UnixMilli(info.Created)
+				if room.Created.IsZero() || created.Before(room.Created) {
+					room.Created = created
+				}
+			}
+		}
+	}
+
+	merge(s.publicRooms())
+
+	var wg sync.WaitGroup
+	for _, info := range candidates {
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			// Bootstrap nodes and older clients do not serve the directory, so failures are expected
+			if infos, err := s.requestRooms(ctx, info); err == nil {
+				merge(infos)
+			}
+		}(info)
+	}
+	wg.Wait()
+
+	list := make([]PublicRoom, 0, len(rooms))
+	for _, room := range rooms {
+		list = append(list, *room)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Members != list[j].Members {
+			return list[i].Members > list[j].Members
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
@@ -0,0 +1,221 @@
+package blue_otter_client
+
+// discovery.go contains all functions related to finding other Blue Otter peers through the DHT, globally and per room
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+const (
+	// roomNamespacePrefix starts the discovery namespace of each room
+	roomNamespacePrefix = "blue-otter-room/"
+	// globalDiscoveryInterval is how often the global namespace is searched for more DHT peers
+	globalDiscoveryInterval = time.Minute
+	// roomDiscoveryInterval is how often a room namespace is searched for more members
+	roomDiscoveryInterval = 30 * time.Second
+	// roomDiscoveryRetry is how often a room namespace is searched while no member has been found
+	roomDiscoveryRetry = 5 * time.Second
+	// roomPeerTarget is how many connected members of a room stop the search for more
+	roomPeerTarget = 16
+	// advertiseRetryInterval is how long to wait before advertising again after a failed attempt
+	advertiseRetryInterval = 10 * time.Second
+	// deadPeerBackoff is how long a peer that could not be dialed is skipped
+	deadPeerBackoff = 20 * time.Minute
+)
+
+// RoomNamespace returns the discovery namespace members of a room advertise themselves under. The room name is
+// hashed so every namespace has the same length whatever the room is called.
+func RoomNamespace(roomName string) string {
+	sum := sha256.Sum256([]byte(roomName))
+	return roomNamespacePrefix + hex.EncodeToString(sum[:16])
+}
+
+// peerDiscovery finds other Blue Otter peers through the DHT. Only a few peers found under the global namespace are
+// connected to keep the DHT healthy, members of joined rooms are found under the namespace of each room.
+type peerDiscovery struct {
+	host    host.Host
+	routing *routing.RoutingDiscovery
+	private bool
+	events  EventSink
+
+	mu        sync.Mutex
+	deadPeers map[peer.ID]time.Time
+	global    map[peer.ID]bool
+}
+
+func newPeerDiscovery(host host.Host, disc *routing.RoutingDiscovery, private bool, events EventSink) *peerDiscovery {
+	return &peerDiscovery{
+		host:      host,
+		routing:   disc,
+		private:   private,
+		events:    events,
+		deadPeers: make(map[peer.ID]time.Time),
+		global:    make(map[peer.ID]bool),
+	}
+}
+
+// isEmptyTableError reports the error returned while the routing table is still empty, which is not worth reporting
+func isEmptyTableError(err error) bool {
+	return err.Error() == "failed to find any peer in table"
+}
+
+// advertise renews an advertisement before it expires. Rooms are usually joined before the routing table has
+// filled, so failed attempts are retried soon rather than after the usual interval.
+func (d *peerDiscovery) advertise(ctx context.Context, ns string) {
+	for {
+		wait := advertiseRetryInterval
+		ttl, err := d.routing.Advertise(ctx, ns)
+		if err == nil {
+			wait = 7 * ttl / 8
+		} else if ctx.Err() == nil && !isEmptyTableError(err) {
+			d.events.HandleEvent(DiscoveryErrorEvent{Op: DiscoveryAdvertise, Err: err})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// connect dials a discovered peer unless we are connected already or it failed recently, reporting whether we are
+// connected afterwards
+func (d *peerDiscovery) connect(ctx context.Context, info peer.AddrInfo, source string) bool {
+	if info.ID == d.host.ID() || len(info.Addrs) == 0 {
+		return false
+	}
+	if d.host.Network().Connectedness(info.ID) == network.Connected {
+		return true
+	}
+
+	d.mu.Lock()
+	nextRetry, dead := d.deadPeers[info.ID]
+	d.mu.Unlock()
+	if dead && time.Now().Before(nextRetry) {
+		return false
+	}
+
+	d.events.HandleEvent(logEvent("Discovery", "Connecting to peer from %s: %s", source, info.ID))
+	err := d.host.Connect(ctx, info)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err != nil {
+		d.events.HandleEvent(DiscoveryErrorEvent{Op: DiscoveryConnect, PeerID: info.ID, Err: management.ExplainHandshakeError(err, d.private), RetryIn: deadPeerBackoff})
+		d.deadPeers[info.ID] = time.Now().Add(deadPeerBackoff)
+		return false
+	}
+	delete(d.deadPeers, info.ID)
+	return true
+}
+
+// globalPeers counts the peers found under the global namespace we are still connected to
+func (d *peerDiscovery) globalPeers() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id := range d.global {
+		if d.host.Network().Connectedness(id) != network.Connected {
+			delete(d.global, id)
+		}
+	}
+	return len(d.global)
+}
+
+// discoverGlobal advertises us under the global namespace and keeps up to common.GlobalPeerLimit connections to
+// peers found there, so the DHT stays reachable even when no joined room has other members
+func (d *peerDiscovery) discoverGlobal(ctx context.Context) {
+	go d.advertise(ctx, common.GlobalNamespace)
+
+	for {
+		wait := globalDiscoveryInterval
+		if d.globalPeers() < common.GlobalPeerLimit {
+			if err := d.findGlobal(ctx); err != nil {
+				if !isEmptyTableError(err) {
+					d.events.HandleEvent(DiscoveryErrorEvent{Op: DiscoveryFindPeers, Err: err})
+				}
+				wait = advertiseRetryInterval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (d *peerDiscovery) findGlobal(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	peerChan, err := d.routing.FindPeers(ctx, common.GlobalNamespace)
+	if err != nil {
+		return err
+	}
+
+	for info := range peerChan {
+		if d.globalPeers() >= common.GlobalPeerLimit {
+			return nil
+		}
+		if d.connect(ctx, info, "global peer list") {
+			d.mu.Lock()
+			d.global[info.ID] = true
+			d.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// discoverRoom connects to members of a room found under its namespace until roomPeerTarget of them are subscribed
+// to topic, searching more often while nobody has been found
+func (d *peerDiscovery) discoverRoom(ctx context.Context, roomName string, topic *pubsub.Topic) {
+	ns := RoomNamespace(roomName)
+
+	for {
+		if len(topic.ListPeers()) < roomPeerTarget {
+			if err := d.findRoom(ctx, ns, roomName); err != nil && ctx.Err() == nil && !isEmptyTableError(err) {
+				d.events.HandleEvent(DiscoveryErrorEvent{Op: DiscoveryFindPeers, Err: err})
+			}
+		}
+
+		wait := roomDiscoveryInterval
+		if len(topic.ListPeers()) == 0 {
+			wait = roomDiscoveryRetry
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (d *peerDiscovery) findRoom(ctx context.Context, ns string, roomName string) error {
+	peerChan, err := d.routing.FindPeers(ctx, ns, discovery.Limit(roomPeerTarget))
+	if err != nil {
+		return err
+	}
+
+	for info := range peerChan {
+		d.connect(ctx, info, roomName)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package blue_otter_client
+
+// dm.go contains all functions related to direct one-to-one messages between peers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// DirectMessageProtocol is the stream protocol used to deliver direct messages
+const DirectMessageProtocol = protocol.ID("/blue-otter/dm/1.0.0")
+
+// maxDirectMessageSize bounds how much a remote peer can make us read for a single direct message
+const maxDirectMessageSize = 64 * 1024
+
+// DirectMessenger sends and receives direct messages over DirectMessageProtocol
+type DirectMessenger struct {
+	host       host.Host
+	identities *identityBook
+	events     EventSink
+}
+
+func newDirectMessenger(identities *identityBook, events EventSink) *DirectMessenger {
+	return &DirectMessenger{
+		identities: identities,
+		events:     events,
+	}
+}
+
+// attach registers the direct message stream handler on host
+func (dm *DirectMessenger) attach(host host.Host) {
+	dm.host = host
+	host.SetStreamHandler(DirectMessageProtocol, dm.handleStream)
+}
+
+func (dm *DirectMessenger) handleStream(s network.Stream) {
+	defer s.Close()
+
+	// The secure channel has already authenticated the remote peer, so this is who really sent the message
+	from := s.Conn().RemotePeer()
+
+	s.SetReadDeadline(time.Now().Add(30 * time.Second))
+	data, err := bufio.NewReader(io.LimitReader(s, maxDirectMessageSize)).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		dm.events.HandleEvent(logEvent("DM", "Failed to read direct message from %s: %v", from, err))
+		s.Reset()
+		return
+	}
+
+	env, err := common.DecodeEnvelope(data)
+	if err != nil || env.Kind != common.KindDirect {
+		dm.events.HandleEvent(logEvent("DM", "Dropping malformed direct message from %s", from))
+		s.Reset()
+		return
+	}
+
+	if err := VerifySender(env, from); err != nil {
+		dm.events.HandleEvent(logEvent("Security", "Dropped direct message from %s: %v", from, err))
+		s.Reset()
+		return
+	}
+
+	payload, err := env.DecodePayload()
+	if err != nil {
+		dm.events.HandleEvent(logEvent("DM", "Dropping direct message from %s: %v", from, err))
+		s.Reset()
+		return
+	}
+
+	msg := payload.(common.DirectMessage)
+	if owner, clash := dm.identities.Observe(msg.Sender, from); clash {
+		dm.events.HandleEvent(logEvent("Security", "Possible impersonation: %s is also used by %s (first seen from %s)", msg.Sender, from, owner))
+	}
+
+	dm.events.HandleEvent(MessageEvent{
+		Direct:    true,
+		ID:        env.ID,
+		PeerID:    from.String(),
+		Sender:    msg.Sender,
+		Text:      msg.Text,
+		Timestamp: env.Timestamp,
+	})
+}
+
+// Resolve turns a username seen in the room or a peer ID string into a peer ID
+func (dm *DirectMessenger) Resolve(target string) (peer.ID, error) {
+	if id, err := peer.Decode(target); err == nil {
+		return id, nil
+	}
+
+	if id, found := dm.identities.Lookup(target); found {
+		return id, nil
+	}
+
+	return "", fmt.Errorf("unknown user or peer ID: %s", target)
+}
+
+// Send delivers a direct message from username to the peer identified by target
+func (dm *DirectMessenger) Send(ctx context.Context, target string, username string, text string) (peer.ID, error) {
+	to, err := dm.Resolve(target)
+	if err != nil {
+		return "", err
+	}
+
+	if to == dm.host.ID() {
+		return "", errors.New("cannot send a direct message to yourself")
+	}
+
+	env, err := common.NewEnvelope(common.KindDirect, dm.host.ID().String(), common.DirectMessage{Sender: username, Text: text})
+	if err != nil {
+		return "", err
+	}
+
+	if err := env.Sign(dm.host.Peerstore().PrivKey(dm.host.ID())); err != nil {
+		return "", err
+	}
+
+	data, err := common.EncodeEnvelope(env)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode direct message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	s, err := dm.host.NewStream(ctx, to, DirectMessageProtocol)
+	if err != nil {
+		return "", fmt.Errorf("failed to open direct message stream to %s: %w", to, err)
+	}
+	defer s.Close()
+
+	s.SetWriteDeadline(time.Now().Add(15 * time.Second))
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		s.Reset()
+		return "", fmt.Errorf("failed to send direct message to %s: %w", to, err)
+	}
+
+	return to, nil
+}
@@ -0,0 +1,293 @@
+package blue_otter_client
+
+// events.go contains the typed events the client reports to frontends and the EventSink interface that receives them
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// Event is anything the client reports to a frontend. String renders the event as a single log line.
+type Event interface {
+	String() string
+}
+
+// EventSink receives every event produced by a session. HandleEvent may be called from any goroutine.
+type EventSink interface {
+	HandleEvent(ev Event)
+}
+
+// EventSinkFunc adapts a function to the EventSink interface
+type EventSinkFunc func(ev Event)
+
+// HandleEvent calls f(ev)
+func (f EventSinkFunc) HandleEvent(ev Event) {
+	f(ev)
+}
+
+// MessageEvent reports a chat or direct message accepted by the session
+type MessageEvent struct {
+	Room      string `json:"room,omitempty"`
+	Direct    bool   `json:"direct,omitempty"`
+	Synced    bool   `json:"synced,omitempty"`
+	ID        string `json:"id"`
+	PeerID    string `json:"peer_id"`
+	Sender    string `json:"sender"`
+	Text      string `json:"text"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (e MessageEvent) fingerprint() string {
+	if id, err := peer.Decode(e.PeerID); err == nil {
+		return Fingerprint(id)
+	}
+	return e.PeerID
+}
+
+func (e MessageEvent) String() string {
+	if e.Direct {
+		return fmt.Sprintf("[DM from %s ✓%s]: %s", e.Sender, e.fingerprint(), e.Text)
+	}
+	return fmt.Sprintf("[%s] <%s ✓%s>: %s", e.Room, e.Sender, e.fingerprint(), e.Text)
+}
+
+// HistoryLine renders the message with the time it was sent, for messages shown out of order
+func (e MessageEvent) HistoryLine() string {
+	sent := time.UnixMilli(e.Timestamp).Format("Jan 2 15:04")
+	return fmt.Sprintf("[%s | %s] <%s ✓%s>: %s", e.Room, sent, e.Sender, e.fingerprint(), e.Text)
+}
+
+// HistoryEvent reports a batch of older room messages, loaded from local history or synced from other members
+type HistoryEvent struct {
+	Room     string
+	Synced   bool
+	Messages []MessageEvent
+}
+
+func (e HistoryEvent) String() string {
+	if e.Synced {
+		return fmt.Sprintf("[%s] --- %d missed messages from other members ---", e.Room, len(e.Messages))
+	}
+	return fmt.Sprintf("[%s] --- %d earlier messages, use /history <n> for more ---", e.Room, len(e.Messages))
+}
+
+// Footer returns the line closing the batch
+func (e HistoryEvent) Footer() string {
+	if e.Synced {
+		return fmt.Sprintf("[%s] --- end of missed messages ---", e.Room)
+	}
+	return fmt.Sprintf("[%s] --- end of history ---", e.Room)
+}
+
+// UnreadableEvent reports a room message that could not be parsed or decrypted
+type UnreadableEvent struct {
+	Room   string
+	PeerID peer.ID
+	Reason error
+	Data   []byte
+}
+
+func (e UnreadableEvent) String() string {
+	switch {
+	case errors.Is(e.Reason, ErrNoRoomKey):
+		return fmt.Sprintf("[%s] <%s> (encrypted: no room secret configured)", e.Room, Fingerprint(e.PeerID))
+	case errors.Is(e.Reason, common.ErrUndecryptable):
+		return fmt.Sprintf("[%s] <%s> (undecryptable: wrong room secret?)", e.Room, Fingerprint(e.PeerID))
+	default:
+		return fmt.Sprintf("[%s] <%s> (unparsed): %s", e.Room, e.PeerID, string(e.Data))
+	}
+}
+
+// NotificationEvent reports a system notification published in a room, such as a join or leave
+type NotificationEvent struct {
+	Room    string
+	PeerID  peer.ID
+	Type    string
+	Message string
+}
+
+func (e NotificationEvent) String() string {
+	return fmt.Sprintf("[%s | notification] %s", e.Room, e.Message)
+}
+
+// RosterEvent reports the current members of a room whenever someone arrives, leaves, changes status or times out
+type RosterEvent struct {
+	Room    string
+	Members []Member
+}
+
+func (e RosterEvent) String() string {
+	timedOut := 0
+	for _, m := range e.Members {
+		if m.TimedOut {
+			timedOut++
+		}
+	}
+	return fmt.Sprintf("[%s | roster] %d members present, %d timed out", e.Room, len(e.Members)-timedOut, timedOut)
+}
+
+// ModerationEvent reports a moderation action taken in a room by its owner or a moderator
+type ModerationEvent struct {
+	Room       string
+	Actor      peer.ID
+	ActorName  string
+	Action     string
+	Target     peer.ID
+	TargetName string
+	Until      time.Time
+	Reason     string
+}
+
+func (e ModerationEvent) String() string {
+	line := fmt.Sprintf("[%s | moderation] %s: %s %s", e.Room, memberName(e.ActorName, e.Actor), e.Action, memberName(e.TargetName, e.Target))
+	if !e.Until.IsZero() {
+		line += fmt.Sprintf(" until %s", e.Until.Format("Jan 2 15:04"))
+	}
+	if e.Reason != "" {
+		line += fmt.Sprintf(" (%s)", e.Reason)
+	}
+	return line
+}
+
+// memberName renders a room member by username and fingerprint, or by fingerprint alone when the username is unknown
+func memberName(username string, id peer.ID) string {
+	if username == "" {
+		return "✓" + Fingerprint(id)
+	}
+	return fmt.Sprintf("%s ✓%s", username, Fingerprint(id))
+}
+
+// PartedEvent reports that we left a room without being asked to, such as after being banned from it
+type PartedEvent struct {
+	Room   string
+	Reason string
+}
+
+func (e PartedEvent) String() string {
+	return fmt.Sprintf("[%s | moderation] Left the room: %s", e.Room, e.Reason)
+}
+
+// ConnectionEvent reports a connection to a peer opening or closing
+type ConnectionEvent struct {
+	PeerID    peer.ID
+	Addr      string
+	Connected bool
+}
+
+func (e ConnectionEvent) String() string {
+	if e.Connected {
+		return fmt.Sprintf("[Networking] Connected to peer: %s via %s", e.PeerID, e.Addr)
+	}
+	return fmt.Sprintf("[Networking] Disconnected from peer: %s via %s", e.PeerID, e.Addr)
+}
+
+// Discovery operations reported by DiscoveryErrorEvent
+const (
+	DiscoveryAdvertise = "advertising"
+	DiscoveryFindPeers = "finding peers"
+	DiscoveryConnect   = "connecting to peer"
+)
+
+// DiscoveryErrorEvent reports a failure in the peer discovery loop
+type DiscoveryErrorEvent struct {
+	Op      string
+	PeerID  peer.ID
+	Err     error
+	RetryIn time.Duration
+}
+
+func (e DiscoveryErrorEvent) String() string {
+	line := fmt.Sprintf("[Discovery] Error %s: %v", e.Op, e.Err)
+	if e.PeerID != "" {
+		line = fmt.Sprintf("[Discovery] Error %s %s: %v", e.Op, e.PeerID, e.Err)
+	}
+	if e.RetryIn > 0 {
+		line += fmt.Sprintf(" (retrying in %s)", e.RetryIn)
+	}
+	return line
+}
+
+// NetworkStatusEvent reports the network status changing between offline, degraded and online
+type NetworkStatusEvent struct {
+	Status              string
+	Bootstraps          int
+	ConnectedBootstraps int
+	RoutingTable        int
+	Peers               int
+}
+
+func (e NetworkStatusEvent) String() string {
+	line := fmt.Sprintf("[Networking] Network %s: %d peers, %d in the routing table", e.Status, e.Peers, e.RoutingTable)
+	if e.Bootstraps > 0 {
+		line += fmt.Sprintf(", %d of %d bootstrap nodes connected", e.ConnectedBootstraps, e.Bootstraps)
+	}
+	return line
+}
+
+// LogEvent is a free-form status line, such as networking progress or a security warning
+type LogEvent struct {
+	Category string
+	Message  string
+}
+
+func (e LogEvent) String() string {
+	return fmt.Sprintf("[%s] %s", e.Category, e.Message)
+}
+
+func logEvent(category string, format string, args ...any) LogEvent {
+	return LogEvent{Category: category, Message: fmt.Sprintf(format, args...)}
+}
+
+// WriterSink writes every event as a plain line, for headless use
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a sink writing to w, typically os.Stdout
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// HandleEvent writes ev to the underlying writer
+func (s *WriterSink) HandleEvent(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(s.w, ev.String())
+	if h, ok := ev.(HistoryEvent); ok {
+		for _, msg := range h.Messages {
+			fmt.Fprintln(s.w, msg.HistoryLine())
+		}
+		fmt.Fprintln(s.w, h.Footer())
+	}
+}
+
+// RecordingSink keeps every event it receives, for tests and tools that inspect what a session reported.
+// The zero value is ready to use.
+type RecordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// HandleEvent records ev
+func (s *RecordingSink) HandleEvent(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, ev)
+}
+
+// Events returns the events recorded so far, oldest first
+func (s *RecordingSink) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Event(nil), s.events...)
+}
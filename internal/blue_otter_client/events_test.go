@@ -0,0 +1,105 @@
+package blue_otter_client
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRecordingSink(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+	}{
+		{name: "nothing recorded"},
+		{name: "single event", events: []Event{LogEvent{Category: "Networking", Message: "online"}}},
+		{
+			name: "kept in order",
+			events: []Event{
+				NotificationEvent{Room: "room", Type: "join", Message: "joined"},
+				MessageEvent{Room: "room", ID: "1", Sender: "Alice", Text: "hello"},
+				NotificationEvent{Room: "room", Type: "leave", Message: "left"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sink RecordingSink
+			for _, ev := range tt.events {
+				sink.HandleEvent(ev)
+			}
+
+			got := sink.Events()
+			if len(got) != len(tt.events) {
+				t.Fatalf("Events() returned %d events, want %d", len(got), len(tt.events))
+			}
+			for i := range got {
+				if got[i].String() != tt.events[i].String() {
+					t.Errorf("event %d = %q, want %q", i, got[i], tt.events[i])
+				}
+			}
+
+			// Callers get a copy, so changing it leaves the recording alone
+			if len(got) > 0 {
+				got[0] = LogEvent{Category: "Test", Message: "changed"}
+				if sink.Events()[0].String() != tt.events[0].String() {
+					t.Error("changing the returned events changed the recording")
+				}
+			}
+		})
+	}
+}
+
+func TestRecordingSinkConcurrent(t *testing.T) {
+	var sink RecordingSink
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				sink.HandleEvent(LogEvent{Category: "Test", Message: fmt.Sprintf("%d-%d", i, j)})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := len(sink.Events()); got != 800 {
+		t.Errorf("recorded %d events, want 800", got)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	history := HistoryEvent{Room: "room", Messages: []MessageEvent{
+		{Room: "room", ID: "1", PeerID: "peer", Sender: "Alice", Text: "first"},
+		{Room: "room", ID: "2", PeerID: "peer", Sender: "Bob", Text: "second"},
+	}}
+
+	tests := []struct {
+		name string
+		ev   Event
+		want []string
+	}{
+		{name: "log line", ev: LogEvent{Category: "Networking", Message: "online"}, want: []string{"[Networking] online"}},
+		{
+			name: "history batch",
+			ev:   history,
+			want: []string{history.String(), history.Messages[0].HistoryLine(), history.Messages[1].HistoryLine(), history.Footer()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			NewWriterSink(&buf).HandleEvent(tt.ev)
+
+			got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package blue_otter_client
+
+// historysync.go contains all functions related to fetching recent room history from other room members
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+// HistorySyncProtocol is the request/response stream protocol used to fetch room history from peers
+const HistorySyncProtocol = protocol.ID("/blue-otter/history/1.0.0")
+
+const (
+	// maxHistorySyncMessages caps how many envelopes a single sync response may carry
+	maxHistorySyncMessages = 200
+	// maxHistorySyncSize caps the encoded size of a single sync response, in bytes
+	maxHistorySyncSize = 1024 * 1024
+	// historySyncWindow is how many of the most recently stored messages are read to answer a sync request or to
+	// find where our own history ends, so a request never costs a read of the whole log
+	historySyncWindow = 1000
+	// historySyncPeers is how many room members are asked for history after joining
+	historySyncPeers = 3
+	// historySyncDelay gives the GossipSub mesh time to form before looking for room members
+	historySyncDelay = 5 * time.Second
+)
+
+// HistoryRequest asks a room member for the messages it has stored for a room
+type HistoryRequest struct {
+	Room    string `json:"room"`
+	Since   int64  `json:"since"`
+	AfterID string `json:"after_id,omitempty"`
+	Limit   int    `json:"limit"`
+}
+
+// handleHistoryRequest serves stored envelopes for a room to a peer that is a member of it
+func (s *Session) handleHistoryRequest(st network.Stream) {
+	defer st.Close()
+
+	from := st.Conn().RemotePeer()
+
+	st.SetDeadline(time.Now().Add(30 * time.Second))
+	line, err := bufio.NewReader(io.LimitReader(st, 4096)).ReadBytes('\n')
+	if err != nil {
+		st.Reset()
+		return
+	}
+
+	var req HistoryRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		st.Reset()
+		return
+	}
+
+	// Only share history of rooms we are in, and only with peers subscribed to the same room
+	room, found := s.Room(req.Room)
+	if !found || !containsPeer(room.topic.ListPeers(), from) || room.moderation.isBanned(from, time.Now()) {
+		st.Reset()
+		return
+	}
+
+	envelopes, _, err := management.LoadHistoryPage(req.Room, management.HistoryEnd, historySyncWindow)
+	if err != nil {
+		s.events.HandleEvent(logEvent("History", "Failed to load history for sync request from %s: %v", from, err))
+		st.Reset()
+		return
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > maxHistorySyncMessages {
+		limit = maxHistorySyncMessages
+	}
+
+	matches := envelopesAfter(envelopes, req.AfterID, req.Since)
+	if len(matches) > limit {
+		matches = matches[len(matches)-limit:]
+	}
+
+	writeEnvelopes(st, matches, maxHistorySyncSize)
+}
+
+// writeEnvelopes writes envelopes to a stream, one per line. When they do not all fit in maxSize bytes the oldest
+// are left out.
+func writeEnvelopes(w io.Writer, envelopes []common.Envelope, maxSize int) {
+	var lines [][]byte
+	size := 0
+	for i := len(envelopes) - 1; i >= 0; i-- {
+		data, err := common.EncodeEnvelope(envelopes[i])
+		if err != nil {
+			continue
+		}
+		if size+len(data)+1 > maxSize {
+			break
+		}
+		size += len(data) + 1
+		lines = append(lines, append(data, '\n'))
+	}
+
+	writer := bufio.NewWriter(w)
+	for i := len(lines) - 1; i >= 0; i-- {
+		writer.Write(lines[i])
+	}
+	writer.Flush()
+}
+
+// readEnvelopes reads up to limit envelopes written by writeEnvelopes, in at most maxSize bytes, skipping lines that
+// do not decode
+func readEnvelopes(r io.Reader, limit int, maxSize int) ([]common.Envelope, error) {
+	var envelopes []common.Envelope
+	scanner := bufio.NewScanner(io.LimitReader(r, int64(maxSize)))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDirectMessageSize)
+	for scanner.Scan() && len(envelopes) < limit {
+		env, err := common.DecodeEnvelope(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		envelopes = append(envelopes, env)
+	}
+
+	return envelopes, scanner.Err()
+}
+
+// envelopesAfter returns the envelopes following afterID, or newer than since when afterID is unknown
+func envelopesAfter(envelopes []common.Envelope, afterID string, since int64) []common.Envelope {
+	if afterID != "" {
+		for i, env := range envelopes {
+			if env.ID == afterID {
+				return envelopes[i+1:]
+			}
+		}
+	}
+
+	var matches []common.Envelope
+	for _, env := range envelopes {
+		if env.Timestamp > since {
+			matches = append(matches, env)
+		}
+	}
+	return matches
+}
+
+func containsPeer(peers []peer.ID, id peer.ID) bool {
+	for _, p := range peers {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
+
+// requestHistory asks a single peer for room envelopes described by req
+func (s *Session) requestHistory(ctx context.Context, p peer.ID, req HistoryRequest) ([]common.Envelope, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	st, err := s.Host.NewStream(ctx, p, HistorySyncProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	st.SetDeadline(time.Now().Add(20 * time.Second))
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := st.Write(append(data, '\n')); err != nil {
+		st.Reset()
+		return nil, err
+	}
+	st.CloseWrite()
+
+	return readEnvelopes(st, maxHistorySyncMessages, maxHistorySyncSize)
+}
+
+// syncHistory fetches messages we missed from other room members, merges them by message ID and renders them
+func (s *Session) syncHistory(ctx context.Context, room *Room) {
+	peers := room.topic.ListPeers()
+	if len(peers) == 0 {
+		return
+	}
+	if len(peers) > historySyncPeers {
+		peers = peers[:historySyncPeers]
+	}
+
+	local, _, err := management.LoadHistoryPage(room.Name, management.HistoryEnd, historySyncWindow)
+	if err != nil {
+		s.events.HandleEvent(logEvent("History", "Warning: Failed to load history for %s: %v", room.Name, err))
+	}
+
+	req := HistoryRequest{Room: room.Name, Limit: maxHistorySyncMessages}
+	if len(local) > 0 {
+		last := local[len(local)-1]
+		req.AfterID = last.ID
+		req.Since = last.Timestamp
+	} else {
+		req.Since = time.Now().Add(-24 * time.Hour).UnixMilli()
+	}
+
+	// Messages we stored are compared against directly, a room only remembers the most recent IDs it has seen
+	stored := make(map[string]bool)
+	for _, env := range local {
+		stored[env.ID] = true
+	}
+
+	// Members may answer with forged envelopes reusing the IDs of real messages, so the first envelope that verifies
+	// is kept for each ID and IDs are only marked seen once verified
+	merged := make(map[string]MessageEvent)
+	synced := make(map[string]common.Envelope)
+	for _, p := range peers {
+		envelopes, err := s.requestHistory(ctx, p, req)
+		if err != nil {
+			s.events.HandleEvent(logEvent("History", "Sync with %s failed: %v", p, err))
+			continue
+		}
+		for _, outer := range envelopes {
+			if _, found := merged[outer.ID]; found || stored[outer.ID] {
+				continue
+			}
+			if msg, ok := s.verifySynced(room, outer); ok {
+				merged[outer.ID] = msg
+				synced[outer.ID] = outer
+			}
+		}
+	}
+
+	var messages []MessageEvent
+	for id, msg := range merged {
+		if room.markSeen(id) {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Timestamp < messages[j].Timestamp
+	})
+
+	for _, msg := range messages {
+		if err := s.saveHistory(room, synced[msg.ID]); err != nil {
+			s.events.HandleEvent(logEvent("History", "Warning: Failed to save message: %v", err))
+		}
+	}
+
+	s.events.HandleEvent(HistoryEvent{Room: room.Name, Synced: true, Messages: messages})
+}
+
+// verifySynced opens a chat message another member stored for a room, reporting false when it does not verify or
+// comes from a member who is banned or muted
+func (s *Session) verifySynced(room *Room, outer common.Envelope) (MessageEvent, bool) {
+	from, err := peer.Decode(outer.Sender)
+	if err != nil {
+		return MessageEvent{}, false
+	}
+
+	env, payload, err := s.openEnvelope(room, outer, from)
+	if err != nil {
+		return MessageEvent{}, false
+	}
+
+	// Other members may have stored messages from before a ban or mute reached them
+	if room.moderation.isBanned(from, time.Now()) || room.moderation.isMuted(from, time.Now()) {
+		return MessageEvent{}, false
+	}
+
+	p, ok := payload.(common.ChatMessage)
+	if !ok {
+		return MessageEvent{}, false
+	}
+	return MessageEvent{
+		Room:      room.Name,
+		Synced:    true,
+		ID:        env.ID,
+		PeerID:    from.String(),
+		Sender:    p.Sender,
+		Text:      p.Text,
+		Timestamp: env.Timestamp,
+	}, true
+}
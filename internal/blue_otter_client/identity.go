@@ -0,0 +1,71 @@
+package blue_otter_client
+
+// identity.go contains all functions related to binding chat usernames to libp2p peer identities
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// ErrSenderMismatch is returned when an envelope claims a sender other than the peer that published it
+var ErrSenderMismatch = errors.New("envelope sender does not match publishing peer")
+
+// Fingerprint returns a short, human-comparable suffix of a peer ID
+func Fingerprint(id peer.ID) string {
+	s := id.String()
+	if len(s) <= 6 {
+		return s
+	}
+	return s[len(s)-6:]
+}
+
+// VerifySender checks that env was signed by the peer it was received from
+func VerifySender(env common.Envelope, from peer.ID) error {
+	if env.Sender != from.String() {
+		return fmt.Errorf("%w: claims %s, published by %s", ErrSenderMismatch, env.Sender, from)
+	}
+
+	pub, err := from.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to extract public key of %s: %w", from, err)
+	}
+
+	return env.Verify(pub)
+}
+
+// identityBook remembers which peer first used each username so later claims can be checked
+type identityBook struct {
+	mu    sync.Mutex
+	names map[string]peer.ID
+}
+
+func newIdentityBook() *identityBook {
+	return &identityBook{names: make(map[string]peer.ID)}
+}
+
+// Observe records that username was used by id and returns the peer that already owned the name, if different
+func (b *identityBook) Observe(username string, id peer.ID) (peer.ID, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	owner, found := b.names[username]
+	if !found {
+		b.names[username] = id
+		return "", false
+	}
+
+	return owner, owner != id
+}
+
+// Lookup returns the peer that first used username
+func (b *identityBook) Lookup(username string) (peer.ID, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id, found := b.names[username]
+	return id, found
+}
@@ -0,0 +1,66 @@
+package blue_otter_client
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+func newTestIdentity(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to derive peer ID: %v", err)
+	}
+	return key, id
+}
+
+func newTestPeer(t *testing.T) peer.ID {
+	t.Helper()
+	_, id := newTestIdentity(t)
+	return id
+}
+
+func TestVerifySender(t *testing.T) {
+	key, id := newTestIdentity(t)
+	otherKey, other := newTestIdentity(t)
+
+	tests := []struct {
+		name   string
+		sender peer.ID
+		signer crypto.PrivKey
+		from   peer.ID
+		want   error
+	}{
+		{name: "signed by publisher", sender: id, signer: key, from: id},
+		{name: "claims another sender", sender: other, signer: key, from: id, want: ErrSenderMismatch},
+		{name: "signed by someone else", sender: id, signer: otherKey, from: id, want: common.ErrInvalidSignature},
+		{name: "unsigned", sender: id, from: id, want: common.ErrMissingSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := common.NewEnvelope(common.KindChat, tt.sender.String(), common.ChatMessage{Sender: "Alice", Text: "hello"})
+			if err != nil {
+				t.Fatalf("NewEnvelope: %v", err)
+			}
+			if tt.signer != nil {
+				if err := env.Sign(tt.signer); err != nil {
+					t.Fatalf("Sign: %v", err)
+				}
+			}
+
+			if err := VerifySender(env, tt.from); !errors.Is(err, tt.want) {
+				t.Errorf("VerifySender() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package blue_otter_client
+
+// invite.go contains all functions related to creating and redeeming invitations to invite-only rooms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+var (
+	// ErrNoIdentity is returned when creating an invitation or a room before this node has an identity
+	ErrNoIdentity = errors.New("no identity yet, start the client once to create one")
+	// ErrInviteKeyExists is returned when accepting an invitation would replace a different key saved for the room
+	ErrInviteKeyExists = errors.New("a different key is already saved for this room")
+	// ErrPublicRoom is returned when inviting to a room that is already in use as a public room. Making it
+	// invite-only would cut off every member without the new key, splitting the room in two.
+	ErrPublicRoom = errors.New("room is already in use as a public room, create a new room to invite to")
+)
+
+// CreateInvitation returns a signed invitation to roomName that expires after ttl. Invitations are to rooms we
+// own, so roomName is qualified with our peer ID. The first invitation to a room makes it invite-only by
+// generating its key, which is reported by created, and is refused for rooms we have been in as public rooms.
+func CreateInvitation(roomName string, ttl time.Duration) (inv common.Invitation, created bool, err error) {
+	privKey, err := management.GetPrivateKey()
+	if err != nil {
+		return inv, false, fmt.Errorf("failed to load identity: %w", err)
+	}
+	if privKey == nil {
+		return inv, false, ErrNoIdentity
+	}
+
+	self, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return inv, false, err
+	}
+	if owner := common.RoomOwner(roomName); owner != "" && owner != self {
+		return inv, false, fmt.Errorf("%w: %s is owned by %s", common.ErrNotRoomOwner, roomName, owner)
+	}
+	roomName = OwnedRoomName(roomName, self)
+
+	key, err := management.LoadInviteKey(roomName)
+	if err != nil {
+		return inv, false, err
+	}
+
+	if key == nil {
+		used, err := management.HasRoomLogs(roomName)
+		if err != nil {
+			return inv, false, err
+		}
+		if used {
+			return inv, false, fmt.Errorf("%w: %s", ErrPublicRoom, roomName)
+		}
+
+		key, err = common.GenerateRoomKey()
+		if err != nil {
+			return inv, false, err
+		}
+		if err := management.SaveInviteKey(roomName, key); err != nil {
+			return inv, false, err
+		}
+		created = true
+	}
+
+	inv, err = common.NewInvitation(roomName, key, ttl, privKey)
+	return inv, created, err
+}
+
+// AcceptInvitation checks an invitation token and saves the room key it carries, so the room can be joined by name
+// from then on. A different key already saved for the room is only replaced when replace is set.
+func AcceptInvitation(token string, replace bool) (common.Invitation, error) {
+	inv, err := common.DecodeInvitation(token)
+	if err != nil {
+		return inv, err
+	}
+
+	saved, err := management.LoadInviteKey(inv.Room)
+	if err != nil {
+		return inv, err
+	}
+	if bytes.Equal(saved, inv.Key) {
+		return inv, nil
+	}
+	if saved != nil && !replace {
+		return inv, fmt.Errorf("%w: %s", ErrInviteKeyExists, inv.Room)
+	}
+
+	if err := management.SaveInviteKey(inv.Room, inv.Key); err != nil {
+		return inv, fmt.Errorf("failed to save room key: %w", err)
+	}
+
+	return inv, nil
+}
+
+// inviteRoomKey returns the key of an invite-only room, or nil when we were never invited to the room
+func inviteRoomKey(roomName string) (*common.RoomKey, error) {
+	key, err := management.LoadInviteKey(roomName)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return common.NewRoomKey(roomName, key)
+}
@@ -0,0 +1,80 @@
+package blue_otter_client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+func TestAcceptInvitation(t *testing.T) {
+	ownerKey, owner := newTestIdentity(t)
+	room := OwnedRoomName("room", owner)
+
+	newKey := func(t *testing.T) []byte {
+		t.Helper()
+		key, err := common.GenerateRoomKey()
+		if err != nil {
+			t.Fatalf("GenerateRoomKey: %v", err)
+		}
+		return key
+	}
+
+	tests := []struct {
+		name    string
+		saved   bool
+		same    bool
+		replace bool
+		want    error
+	}{
+		{name: "first invitation"},
+		{name: "same key again", saved: true, same: true},
+		{name: "different key", saved: true, want: ErrInviteKeyExists},
+		{name: "different key replaced", saved: true, replace: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+
+			savedKey := newKey(t)
+			if tt.saved {
+				if err := management.SaveInviteKey(room, savedKey); err != nil {
+					t.Fatalf("SaveInviteKey: %v", err)
+				}
+			}
+			key := newKey(t)
+			if tt.same {
+				key = savedKey
+			}
+
+			inv, err := common.NewInvitation(room, key, time.Hour, ownerKey)
+			if err != nil {
+				t.Fatalf("NewInvitation: %v", err)
+			}
+			token, err := inv.Encode()
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			if _, err := AcceptInvitation(token, tt.replace); !errors.Is(err, tt.want) {
+				t.Fatalf("AcceptInvitation() = %v, want %v", err, tt.want)
+			}
+
+			got, err := management.LoadInviteKey(room)
+			if err != nil {
+				t.Fatalf("LoadInviteKey: %v", err)
+			}
+			want := key
+			if tt.want != nil {
+				want = savedKey
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("saved key = %x, want %x", got, want)
+			}
+		})
+	}
+}
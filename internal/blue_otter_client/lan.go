@@ -0,0 +1,56 @@
+package blue_otter_client
+
+// lan.go contains all functions related to discovering peers on the local network with mDNS
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+// LANServiceName is the mDNS service name Blue Otter peers announce on the local network
+const LANServiceName = "blue-otter"
+
+// lanNotifee connects to every Blue Otter peer announced on the local network
+type lanNotifee struct {
+	ctx     context.Context
+	host    host.Host
+	private bool
+	events  EventSink
+}
+
+// HandlePeerFound is called by the mDNS service for each peer it discovers
+func (n *lanNotifee) HandlePeerFound(info peer.AddrInfo) {
+	if info.ID == n.host.ID() || n.host.Network().Connectedness(info.ID) == network.Connected {
+		return
+	}
+
+	// Connect off the mDNS resolver goroutine so one slow peer does not hold up the rest
+	go func() {
+		if err := n.host.Connect(n.ctx, info); err != nil {
+			n.events.HandleEvent(DiscoveryErrorEvent{Op: DiscoveryConnect, PeerID: info.ID, Err: management.ExplainHandshakeError(err, n.private)})
+			return
+		}
+		n.events.HandleEvent(logEvent("Discovery", "Connected to LAN peer: %s", info.ID))
+	}()
+}
+
+// startLANDiscovery announces the host on the local network and connects to other Blue Otter peers found there
+func startLANDiscovery(ctx context.Context, host host.Host, private bool, events EventSink) error {
+	service := mdns.NewMdnsService(host, LANServiceName, &lanNotifee{ctx: ctx, host: host, private: private, events: events})
+	if err := service.Start(); err != nil {
+		return fmt.Errorf("failed to start mDNS: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		service.Close()
+	}()
+
+	return nil
+}
@@ -0,0 +1,760 @@
+package blue_otter_client
+
+// moderation.go contains all functions related to room ownership and the signed moderation events that ban, kick,
+// mute and promote room members
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+// ModerationSyncProtocol is the stream protocol used to fetch the moderation log of a room from its members
+const ModerationSyncProtocol = protocol.ID("/blue-otter/moderation/1.0.0")
+
+// Moderation actions. The owner promotes and demotes moderators, and the owner and moderators ban, kick and mute
+// members.
+const (
+	ModerationPromote = "promote"
+	ModerationDemote  = "demote"
+	ModerationBan     = "ban"
+	ModerationUnban   = "unban"
+	ModerationKick    = "kick"
+	ModerationMute    = "mute"
+	ModerationUnmute  = "unmute"
+)
+
+// Roles of room members
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+const (
+	// kickDuration is how long a kicked member is kept out of a room
+	kickDuration = 10 * time.Minute
+	// maxModerationSyncEvents caps how many moderation envelopes a single sync response may carry
+	maxModerationSyncEvents = 1000
+	// maxModerationSyncSize caps the encoded size of a single moderation sync response, in bytes
+	maxModerationSyncSize = 4 * 1024 * 1024
+	// maxModerationParents caps how many parent events a moderation event may name
+	maxModerationParents = 256
+)
+
+var (
+	// ErrNotAuthorized is returned when a peer publishes a moderation action it is not allowed to take
+	ErrNotAuthorized = errors.New("not authorized")
+	// ErrBanned is returned when joining a room we are banned from
+	ErrBanned = errors.New("banned from room")
+)
+
+// ModerationState is a snapshot of who runs a room and who is banned or muted in it.
+// A zero expiry means the ban or mute is permanent.
+type ModerationState struct {
+	Owner      peer.ID
+	Moderators []peer.ID
+	Banned     map[peer.ID]time.Time
+	Muted      map[peer.ID]time.Time
+}
+
+// ModerationRequest asks a room member for the moderation log it has stored for a room
+type ModerationRequest struct {
+	Room string `json:"room"`
+}
+
+// moderationEntry is a verified moderation event and the envelope it arrived in
+type moderationEntry struct {
+	// outer is the envelope exactly as it was received, which is what the moderation log stores
+	outer  common.Envelope
+	id     string
+	actor  peer.ID
+	target peer.ID
+	event  common.Moderation
+}
+
+// newModerationEntry pairs a moderation event that actor published in env with the envelope it arrived in
+func newModerationEntry(outer common.Envelope, env common.Envelope, actor peer.ID, ev common.Moderation) (moderationEntry, error) {
+	target, err := peer.Decode(ev.Target)
+	if err != nil {
+		return moderationEntry{}, fmt.Errorf("invalid target %q: %w", ev.Target, err)
+	}
+	return moderationEntry{outer: outer, id: env.ID, actor: actor, target: target, event: ev}, nil
+}
+
+// moderationNode is a moderation event admitted to the graph of a room
+type moderationNode struct {
+	moderationEntry
+	index int
+	// ancestors holds the indexes of every event this one depends on, directly or through its parents
+	ancestors eventSet
+}
+
+// eventSet is a set of moderation event indexes
+type eventSet []uint64
+
+func (s eventSet) has(i int) bool {
+	return i/64 < len(s) && s[i/64]&(1<<(i%64)) != 0
+}
+
+func (s *eventSet) add(i int) {
+	for len(*s) <= i/64 {
+		*s = append(*s, 0)
+	}
+	(*s)[i/64] |= 1 << (i % 64)
+}
+
+func (s *eventSet) union(other eventSet) {
+	for len(*s) < len(other) {
+		*s = append(*s, 0)
+	}
+	for i, word := range other {
+		(*s)[i] |= word
+	}
+}
+
+// moderation is the moderation state of a room. Events form a graph through the parents each one names, and an
+// event is only admitted once all of its parents are, so admission order always follows the graph. Whether a
+// moderator may act is decided by the owner's events that the action depends on, never by timestamps, which the
+// signer chooses. A demotion, ban or kick of a moderator by the owner also overrules every action of that moderator
+// the owner had not seen, so leaving the demotion out of an action's parents does not get around it.
+type moderation struct {
+	mu    sync.Mutex
+	owner peer.ID
+
+	nodes   []*moderationNode
+	byID    map[string]*moderationNode
+	all     eventSet
+	heads   map[string]bool
+	pending map[string]moderationEntry
+	// promotions and revocations index the owner's role events by the member they are about
+	promotions  map[peer.ID][]*moderationNode
+	revocations map[peer.ID][]*moderationNode
+
+	// The state below is rebuilt from the events in effect whenever an event is admitted
+	overruled  map[string]bool
+	moderators map[peer.ID]bool
+	banned     map[peer.ID]time.Time
+	muted      map[peer.ID]time.Time
+}
+
+// newModeration creates the moderation state of a room owned by owner, which is empty for rooms without an owner
+func newModeration(owner peer.ID) *moderation {
+	return &moderation{
+		owner:       owner,
+		byID:        make(map[string]*moderationNode),
+		heads:       make(map[string]bool),
+		pending:     make(map[string]moderationEntry),
+		promotions:  make(map[peer.ID][]*moderationNode),
+		revocations: make(map[peer.ID][]*moderationNode),
+		overruled:   make(map[string]bool),
+		moderators:  make(map[peer.ID]bool),
+		banned:      make(map[peer.ID]time.Time),
+		muted:       make(map[peer.ID]time.Time),
+	}
+}
+
+// untilTime converts the expiry of a moderation event, the zero time meaning it never expires
+func untilTime(until int64) time.Time {
+	if until == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(until)
+}
+
+// inEffect reports whether a ban or mute expiring at until still applies at now
+func inEffect(until time.Time, found bool, now time.Time) bool {
+	return found && (until.IsZero() || now.Before(until))
+}
+
+// revokes reports whether an action by the owner takes away the moderator role of its target
+func revokes(action string) bool {
+	return action == ModerationDemote || action == ModerationBan || action == ModerationKick
+}
+
+// moderatorIn reports whether p is a moderator as far as the events in past are concerned: the owner promoted p
+// there, and every demotion, ban or kick of p there came before that promotion
+func (m *moderation) moderatorIn(p peer.ID, past eventSet) bool {
+	for _, promotion := range m.promotions[p] {
+		if !past.has(promotion.index) {
+			continue
+		}
+		current := true
+		for _, revocation := range m.revocations[p] {
+			if past.has(revocation.index) && !promotion.ancestors.has(revocation.index) {
+				current = false
+				break
+			}
+		}
+		if current {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks that actor may take action against target given every event applied so far
+func (m *moderation) authorize(actor peer.ID, action string, target peer.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.authorizeLocked(actor, action, target, m.all)
+}
+
+// authorizeLocked checks that actor may take action against target given the events in past
+func (m *moderation) authorizeLocked(actor peer.ID, action string, target peer.ID, past eventSet) error {
+	if m.owner == "" {
+		return fmt.Errorf("%w: room has no owner", ErrNotAuthorized)
+	}
+	if target == "" {
+		return fmt.Errorf("%s needs a target", action)
+	}
+
+	switch action {
+	case ModerationPromote, ModerationDemote:
+		if actor != m.owner {
+			return fmt.Errorf("%w: only the owner can %s moderators", ErrNotAuthorized, action)
+		}
+	case ModerationBan, ModerationUnban, ModerationKick, ModerationMute, ModerationUnmute:
+		if actor != m.owner && !m.moderatorIn(actor, past) {
+			return fmt.Errorf("%w: only the owner and moderators can %s members", ErrNotAuthorized, action)
+		}
+	default:
+		return fmt.Errorf("unknown moderation action %q", action)
+	}
+
+	if target == m.owner {
+		return fmt.Errorf("%w: the owner cannot be moderated", ErrNotAuthorized)
+	}
+	if actor != m.owner && m.moderatorIn(target, past) {
+		return fmt.Errorf("%w: only the owner can moderate moderators", ErrNotAuthorized)
+	}
+
+	return nil
+}
+
+// add admits a moderation event, along with any held back events it was the last missing parent of, and returns
+// the events admitted in the order they were. An event naming parents that are not known yet is held back until
+// they arrive, so events can be added in any order. The error is set when the event itself is refused.
+func (m *moderation) add(entry moderationEntry) ([]moderationEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	admitted, err := m.admitLocked(entry)
+	if len(admitted) == 0 {
+		return nil, err
+	}
+
+	// Each admission may complete the parents of a held back event, so keep going until nothing changes
+	for progress := true; progress; {
+		progress = false
+		for id, held := range m.pending {
+			if !m.parentsKnownLocked(held) {
+				continue
+			}
+			delete(m.pending, id)
+			if more, _ := m.admitLocked(held); len(more) > 0 {
+				admitted = append(admitted, more...)
+				progress = true
+			}
+		}
+	}
+
+	m.rebuildLocked()
+	return admitted, err
+}
+
+func (m *moderation) parentsKnownLocked(entry moderationEntry) bool {
+	for _, parent := range entry.event.Parents {
+		if m.byID[parent] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// admitLocked adds a single event to the graph if its parents are known and it was authorized when it was taken
+func (m *moderation) admitLocked(entry moderationEntry) ([]moderationEntry, error) {
+	if m.byID[entry.id] != nil {
+		return nil, nil
+	}
+	if len(entry.event.Parents) > maxModerationParents {
+		return nil, fmt.Errorf("too many parent events (%d)", len(entry.event.Parents))
+	}
+	if !m.parentsKnownLocked(entry) {
+		if len(m.pending) < maxModerationSyncEvents {
+			m.pending[entry.id] = entry
+		}
+		return nil, nil
+	}
+
+	node := &moderationNode{moderationEntry: entry, index: len(m.nodes)}
+	for _, parent := range entry.event.Parents {
+		p := m.byID[parent]
+		node.ancestors.union(p.ancestors)
+		node.ancestors.add(p.index)
+	}
+	if err := m.authorizeLocked(entry.actor, entry.event.Action, entry.target, node.ancestors); err != nil {
+		return nil, err
+	}
+
+	m.nodes = append(m.nodes, node)
+	m.byID[entry.id] = node
+	m.all.add(node.index)
+	for _, parent := range entry.event.Parents {
+		delete(m.heads, parent)
+	}
+	m.heads[entry.id] = true
+
+	if entry.actor == m.owner {
+		switch {
+		case entry.event.Action == ModerationPromote:
+			m.promotions[entry.target] = append(m.promotions[entry.target], node)
+		case revokes(entry.event.Action):
+			m.revocations[entry.target] = append(m.revocations[entry.target], node)
+		}
+	}
+
+	return []moderationEntry{entry}, nil
+}
+
+// concurrent reports whether neither of two events depends on the other
+func concurrent(a, b *moderationNode) bool {
+	return a != b && !a.ancestors.has(b.index) && !b.ancestors.has(a.index)
+}
+
+// overrulesLocked reports whether the owner took away the actor's role, or gave the target one, in an event the
+// actor had not seen and that did not see the action
+func (m *moderation) overrulesLocked(node *moderationNode) bool {
+	if node.actor == m.owner {
+		return false
+	}
+	for _, revocation := range m.revocations[node.actor] {
+		if concurrent(node, revocation) {
+			return true
+		}
+	}
+	for _, promotion := range m.promotions[node.target] {
+		if concurrent(node, promotion) {
+			return true
+		}
+	}
+	return false
+}
+
+// rebuildLocked works out the moderation state from the events in effect. They are applied in an order that only
+// depends on the graph, events that do not depend on each other by ID, so every member ends up with the same state
+// however the events reached it.
+func (m *moderation) rebuildLocked() {
+	m.overruled = make(map[string]bool)
+	m.moderators = make(map[peer.ID]bool)
+	m.banned = make(map[peer.ID]time.Time)
+	m.muted = make(map[peer.ID]time.Time)
+
+	for p := range m.promotions {
+		if m.moderatorIn(p, m.all) {
+			m.moderators[p] = true
+		}
+	}
+
+	children := make(map[string][]*moderationNode)
+	waiting := make(map[string]int)
+	var ready []*moderationNode
+	for _, node := range m.nodes {
+		parents := make(map[string]bool)
+		for _, parent := range node.event.Parents {
+			parents[parent] = true
+		}
+		for parent := range parents {
+			children[parent] = append(children[parent], node)
+		}
+		waiting[node.id] = len(parents)
+		if len(parents) == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool {
+			return ready[i].id < ready[j].id
+		})
+		node := ready[0]
+		ready = ready[1:]
+		for _, child := range children[node.id] {
+			if waiting[child.id]--; waiting[child.id] == 0 {
+				ready = append(ready, child)
+			}
+		}
+
+		if m.overrulesLocked(node) {
+			m.overruled[node.id] = true
+			continue
+		}
+		switch node.event.Action {
+		case ModerationBan, ModerationKick:
+			m.banned[node.target] = untilTime(node.event.Until)
+		case ModerationUnban:
+			delete(m.banned, node.target)
+		case ModerationMute:
+			m.muted[node.target] = untilTime(node.event.Until)
+		case ModerationUnmute:
+			delete(m.muted, node.target)
+		}
+	}
+}
+
+// inForce reports whether an admitted event still applies, rather than being overruled by the owner
+func (m *moderation) inForce(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.byID[id] != nil && !m.overruled[id]
+}
+
+// parents returns the latest events applied, for a new event to name as its parents
+func (m *moderation) parents() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parents := make([]string, 0, len(m.heads))
+	for id := range m.heads {
+		parents = append(parents, id)
+	}
+	// Name the most recently admitted events if there are too many to list
+	sort.Slice(parents, func(i, j int) bool {
+		return m.byID[parents[i]].index > m.byID[parents[j]].index
+	})
+	if len(parents) > maxModerationParents {
+		parents = parents[:maxModerationParents]
+	}
+	return parents
+}
+
+// isBanned reports whether p is banned or kicked from the room at now
+func (m *moderation) isBanned(p peer.ID, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	until, found := m.banned[p]
+	return inEffect(until, found, now)
+}
+
+// isMuted reports whether p is muted in the room at now
+func (m *moderation) isMuted(p peer.ID, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	until, found := m.muted[p]
+	return inEffect(until, found, now)
+}
+
+// role returns the role of p in the room
+func (m *moderation) role(p peer.ID) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case p != "" && p == m.owner:
+		return RoleOwner
+	case m.moderators[p]:
+		return RoleModerator
+	default:
+		return RoleMember
+	}
+}
+
+// snapshot returns the moderation state at now, leaving out bans and mutes that expired
+func (m *moderation) snapshot(now time.Time) ModerationState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := ModerationState{
+		Owner:  m.owner,
+		Banned: make(map[peer.ID]time.Time),
+		Muted:  make(map[peer.ID]time.Time),
+	}
+	for id := range m.moderators {
+		state.Moderators = append(state.Moderators, id)
+	}
+	sort.Slice(state.Moderators, func(i, j int) bool {
+		return state.Moderators[i] < state.Moderators[j]
+	})
+	for id, until := range m.banned {
+		if inEffect(until, true, now) {
+			state.Banned[id] = until
+		}
+	}
+	for id, until := range m.muted {
+		if inEffect(until, true, now) {
+			state.Muted[id] = until
+		}
+	}
+	return state
+}
+
+// OwnedRoomName returns the name of the room roomName owned by owner. The owner is part of the room's identity, so
+// every member knows who owns it without having to trust anyone else.
+func OwnedRoomName(roomName string, owner peer.ID) string {
+	if common.RoomOwner(roomName) == owner {
+		return NormalizeRoomName(roomName)
+	}
+	return NormalizeRoomName(roomName) + common.RoomOwnerSeparator + owner.String()
+}
+
+// OwnRoomName returns the name of the room roomName owned by the saved identity, for creating a room before the
+// client is started
+func OwnRoomName(roomName string) (string, error) {
+	privKey, err := management.GetPrivateKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to load identity: %w", err)
+	}
+	if privKey == nil {
+		return "", ErrNoIdentity
+	}
+	self, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return "", err
+	}
+	return OwnedRoomName(roomName, self), nil
+}
+
+// Moderation returns who owns and moderates the room and who is banned or muted in it
+func (r *Room) Moderation() ModerationState {
+	return r.moderation.snapshot(time.Now())
+}
+
+// Role returns the role of a peer in the room
+func (r *Room) Role(p peer.ID) string {
+	return r.moderation.role(p)
+}
+
+// Moderate publishes a signed moderation action against target in a room. Bans and mutes expire after duration
+// unless it is zero, kicks always expire after ten minutes.
+func (s *Session) Moderate(roomName string, action string, target peer.ID, duration time.Duration, reason string) error {
+	room, found := s.Room(roomName)
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNotInRoom, roomName)
+	}
+	if target == s.Host.ID() {
+		return fmt.Errorf("cannot %s yourself", action)
+	}
+
+	// Refused actions are reported here, every other member would silently ignore them
+	if err := room.moderation.authorize(s.Host.ID(), action, target); err != nil {
+		return err
+	}
+
+	ev := common.Moderation{Action: action, Target: target.String(), Reason: reason, Parents: room.moderation.parents()}
+	if action == ModerationKick {
+		duration = kickDuration
+	}
+	if duration > 0 && (action == ModerationBan || action == ModerationKick || action == ModerationMute) {
+		ev.Until = time.Now().Add(duration).UnixMilli()
+	}
+
+	return PublishMessage(s.ctx, s.Host, room.topic, room.key, common.KindModeration, ev)
+}
+
+// loadModeration replays the moderation log stored for a room, in the order it was written
+func (s *Session) loadModeration(room *Room) error {
+	envelopes, err := management.LoadModerationLog(room.Name)
+	if err != nil {
+		return err
+	}
+	s.mergeModeration(room, envelopes)
+	return nil
+}
+
+// mergeModeration adds stored or synced moderation envelopes to the state of a room and returns the ones that were
+// not known before. Envelopes may come in any order, each one waits for the events it names as parents.
+func (s *Session) mergeModeration(room *Room, envelopes []common.Envelope) []moderationEntry {
+	var admitted []moderationEntry
+	for _, outer := range envelopes {
+		from, err := peer.Decode(outer.Sender)
+		if err != nil {
+			continue
+		}
+
+		env, payload, err := s.openEnvelope(room, outer, from)
+		if err != nil {
+			continue
+		}
+		ev, ok := payload.(common.Moderation)
+		if !ok {
+			continue
+		}
+		entry, err := newModerationEntry(outer, env, from, ev)
+		if err != nil {
+			continue
+		}
+
+		added, _ := room.moderation.add(entry)
+		admitted = append(admitted, added...)
+	}
+	return admitted
+}
+
+// observeModeration applies a moderation event received in a room, saves it and reports it
+func (s *Session) observeModeration(room *Room, env common.Envelope, outer common.Envelope, from peer.ID, ev common.Moderation) {
+	entry, err := newModerationEntry(outer, env, from, ev)
+	if err != nil {
+		s.events.HandleEvent(logEvent("Security", "Ignoring %s in %s from %s: %v", ev.Action, room.Name, from, err))
+		return
+	}
+	admitted, err := room.moderation.add(entry)
+	if err != nil {
+		s.events.HandleEvent(logEvent("Security", "Ignoring %s in %s from %s: %v", ev.Action, room.Name, from, err))
+	}
+
+	removed := false
+	for _, entry := range admitted {
+		if err := management.AppendModerationLog(room.Name, entry.outer); err != nil {
+			s.events.HandleEvent(logEvent("Moderation", "Warning: Failed to save moderation event: %v", err))
+		}
+
+		if !room.moderation.inForce(entry.id) {
+			s.events.HandleEvent(logEvent("Security", "Ignoring %s in %s from %s: %v: overruled by the owner", entry.event.Action, room.Name, entry.actor, ErrNotAuthorized))
+			continue
+		}
+		s.events.HandleEvent(ModerationEvent{
+			Room:       room.Name,
+			Actor:      entry.actor,
+			ActorName:  room.roster.username(entry.actor),
+			Action:     entry.event.Action,
+			Target:     entry.target,
+			TargetName: room.roster.username(entry.target),
+			Until:      untilTime(entry.event.Until),
+			Reason:     entry.event.Reason,
+		})
+
+		if entry.event.Action == ModerationBan || entry.event.Action == ModerationKick {
+			room.roster.remove(entry.target)
+			removed = true
+		}
+	}
+	if removed {
+		s.reportRoster(room)
+	}
+
+	// Everyone else stops listening to a banned member, so it leaves the room rather than talking to nobody
+	if len(admitted) > 0 && room.moderation.isBanned(s.Host.ID(), time.Now()) {
+		go s.partBanned(room.Name)
+	}
+}
+
+// partBanned leaves a room we were banned from and reports it, so that the room is closed wherever it is shown
+func (s *Session) partBanned(roomName string) {
+	if err := s.Part(roomName); err != nil {
+		return
+	}
+	s.events.HandleEvent(PartedEvent{Room: roomName, Reason: "you are banned from this room"})
+}
+
+// handleModerationRequest serves the moderation log of a room to a peer that is a member of it
+func (s *Session) handleModerationRequest(st network.Stream) {
+	defer st.Close()
+
+	from := st.Conn().RemotePeer()
+
+	st.SetDeadline(time.Now().Add(30 * time.Second))
+	line, err := bufio.NewReader(io.LimitReader(st, 4096)).ReadBytes('\n')
+	if err != nil {
+		st.Reset()
+		return
+	}
+
+	var req ModerationRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		st.Reset()
+		return
+	}
+
+	room, found := s.Room(req.Room)
+	if !found || !containsPeer(room.topic.ListPeers(), from) {
+		st.Reset()
+		return
+	}
+
+	envelopes, err := management.LoadModerationLog(req.Room)
+	if err != nil {
+		s.events.HandleEvent(logEvent("Moderation", "Failed to load moderation log for sync request from %s: %v", from, err))
+		st.Reset()
+		return
+	}
+	if len(envelopes) > maxModerationSyncEvents {
+		envelopes = envelopes[len(envelopes)-maxModerationSyncEvents:]
+	}
+
+	writeEnvelopes(st, envelopes, maxModerationSyncSize)
+}
+
+// requestModeration asks a single peer for the moderation log of a room
+func (s *Session) requestModeration(ctx context.Context, p peer.ID, roomName string) ([]common.Envelope, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	st, err := s.Host.NewStream(ctx, p, ModerationSyncProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	st.SetDeadline(time.Now().Add(20 * time.Second))
+
+	data, err := json.Marshal(ModerationRequest{Room: roomName})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := st.Write(append(data, '\n')); err != nil {
+		st.Reset()
+		return nil, err
+	}
+	st.CloseWrite()
+
+	return readEnvelopes(st, maxModerationSyncEvents, maxModerationSyncSize)
+}
+
+// syncModeration fetches moderation events we missed from other room members
+func (s *Session) syncModeration(ctx context.Context, room *Room) {
+	peers := room.topic.ListPeers()
+	if len(peers) > historySyncPeers {
+		peers = peers[:historySyncPeers]
+	}
+
+	var synced []common.Envelope
+	for _, p := range peers {
+		envelopes, err := s.requestModeration(ctx, p, room.Name)
+		if err != nil {
+			s.events.HandleEvent(logEvent("Moderation", "Sync with %s failed: %v", p, err))
+			continue
+		}
+		synced = append(synced, envelopes...)
+	}
+
+	applied := s.mergeModeration(room, synced)
+	for _, entry := range applied {
+		if err := management.AppendModerationLog(room.Name, entry.outer); err != nil {
+			s.events.HandleEvent(logEvent("Moderation", "Warning: Failed to save moderation event: %v", err))
+			break
+		}
+	}
+	if len(applied) > 0 {
+		s.events.HandleEvent(logEvent(room.Name+" | moderation", "Synced %d moderation events from other members", len(applied)))
+	}
+
+	if room.moderation.isBanned(s.Host.ID(), time.Now()) {
+		s.partBanned(room.Name)
+	}
+}
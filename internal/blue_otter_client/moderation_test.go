@@ -0,0 +1,272 @@
+package blue_otter_client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// newTestEntry creates a moderation event that actor takes against target, after the events named as parents
+func newTestEntry(t *testing.T, actor peer.ID, action string, target peer.ID, parents ...string) moderationEntry {
+	t.Helper()
+	ev := common.Moderation{Action: action, Target: target.String(), Parents: parents}
+	env, err := common.NewEnvelope(common.KindModeration, actor.String(), ev)
+	if err != nil {
+		t.Fatalf("NewEnvelope: %v", err)
+	}
+	entry, err := newModerationEntry(env, env, actor, ev)
+	if err != nil {
+		t.Fatalf("newModerationEntry: %v", err)
+	}
+	return entry
+}
+
+// addTestEvents adds events that must be admitted, each one after the previous
+func addTestEvents(t *testing.T, m *moderation, entries ...moderationEntry) {
+	t.Helper()
+	for _, entry := range entries {
+		entry.event.Parents = m.parents()
+		if admitted, err := m.add(entry); err != nil || len(admitted) != 1 {
+			t.Fatalf("add(%s) = %d admitted, %v", entry.event.Action, len(admitted), err)
+		}
+	}
+}
+
+func TestModerationAuthorize(t *testing.T) {
+	owner := newTestPeer(t)
+	mod := newTestPeer(t)
+	otherMod := newTestPeer(t)
+	member := newTestPeer(t)
+	otherMember := newTestPeer(t)
+
+	tests := []struct {
+		name    string
+		owner   peer.ID
+		actor   peer.ID
+		action  string
+		target  peer.ID
+		wantErr bool
+		// wantDenied is set when the error must be ErrNotAuthorized rather than a malformed request
+		wantDenied bool
+	}{
+		{name: "owner bans member", owner: owner, actor: owner, action: ModerationBan, target: member},
+		{name: "owner promotes member", owner: owner, actor: owner, action: ModerationPromote, target: member},
+		{name: "owner bans moderator", owner: owner, actor: owner, action: ModerationBan, target: mod},
+		{name: "moderator bans member", owner: owner, actor: mod, action: ModerationBan, target: member},
+		{name: "moderator mutes member", owner: owner, actor: mod, action: ModerationMute, target: member},
+		{name: "moderator promotes member", owner: owner, actor: mod, action: ModerationPromote, target: member, wantErr: true, wantDenied: true},
+		{name: "moderator bans moderator", owner: owner, actor: mod, action: ModerationBan, target: otherMod, wantErr: true, wantDenied: true},
+		{name: "moderator bans owner", owner: owner, actor: mod, action: ModerationBan, target: owner, wantErr: true, wantDenied: true},
+		{name: "member bans member", owner: owner, actor: member, action: ModerationBan, target: otherMember, wantErr: true, wantDenied: true},
+		{name: "member kicks owner", owner: owner, actor: member, action: ModerationKick, target: owner, wantErr: true, wantDenied: true},
+		{name: "room without owner", actor: member, action: ModerationBan, target: otherMember, wantErr: true, wantDenied: true},
+		{name: "unknown action", owner: owner, actor: owner, action: "shout", target: member, wantErr: true},
+		{name: "no target", owner: owner, actor: owner, action: ModerationBan, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newModeration(tt.owner)
+			if tt.owner != "" {
+				addTestEvents(t, m, newTestEntry(t, owner, ModerationPromote, mod), newTestEntry(t, owner, ModerationPromote, otherMod))
+			}
+
+			err := m.authorize(tt.actor, tt.action, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authorize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrNotAuthorized) != tt.wantDenied {
+				t.Errorf("authorize() error = %v, want ErrNotAuthorized %v", err, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestModerationAdd(t *testing.T) {
+	owner := newTestPeer(t)
+	member := newTestPeer(t)
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		actor      peer.ID
+		action     string
+		until      time.Time
+		wantBanned bool
+		wantMuted  bool
+	}{
+		{name: "permanent ban", actor: owner, action: ModerationBan, wantBanned: true},
+		{name: "ban in effect", actor: owner, action: ModerationBan, until: now.Add(time.Hour), wantBanned: true},
+		{name: "ban expired", actor: owner, action: ModerationBan, until: now.Add(-time.Hour)},
+		{name: "kick", actor: owner, action: ModerationKick, until: now.Add(kickDuration), wantBanned: true},
+		{name: "mute", actor: owner, action: ModerationMute, wantMuted: true},
+		{name: "ban by member", actor: member, action: ModerationBan},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newModeration(owner)
+			entry := newTestEntry(t, tt.actor, tt.action, member)
+			if !tt.until.IsZero() {
+				entry.event.Until = tt.until.UnixMilli()
+			}
+
+			admitted, err := m.add(entry)
+			if tt.actor != owner {
+				if err == nil || len(admitted) != 0 {
+					t.Fatalf("add() = %d admitted, %v, want the event refused", len(admitted), err)
+				}
+			} else if err != nil || len(admitted) != 1 {
+				t.Fatalf("add() = %d admitted, %v, want the event admitted", len(admitted), err)
+			}
+
+			if got := m.isBanned(member, now); got != tt.wantBanned {
+				t.Errorf("isBanned() = %v, want %v", got, tt.wantBanned)
+			}
+			if got := m.isMuted(member, now); got != tt.wantMuted {
+				t.Errorf("isMuted() = %v, want %v", got, tt.wantMuted)
+			}
+
+			// Adding the same event again, as a sync does, changes nothing
+			if admitted, err := m.add(entry); len(admitted) != 0 {
+				t.Errorf("add() admitted the same event twice, err = %v", err)
+			}
+		})
+	}
+}
+
+func TestModerationOrdering(t *testing.T) {
+	owner := newTestPeer(t)
+	mod := newTestPeer(t)
+	otherMod := newTestPeer(t)
+	member := newTestPeer(t)
+
+	// Each case builds its events in the order they were taken, every event naming the earlier ones it saw
+	tests := []struct {
+		name       string
+		events     func(t *testing.T) []moderationEntry
+		wantBanned bool
+	}{
+		{
+			name: "ban after promotion",
+			events: func(t *testing.T) []moderationEntry {
+				promote := newTestEntry(t, owner, ModerationPromote, mod)
+				ban := newTestEntry(t, mod, ModerationBan, member, promote.id)
+				return []moderationEntry{promote, ban}
+			},
+			wantBanned: true,
+		},
+		{
+			name: "ban without parents",
+			events: func(t *testing.T) []moderationEntry {
+				promote := newTestEntry(t, owner, ModerationPromote, mod)
+				ban := newTestEntry(t, mod, ModerationBan, member)
+				return []moderationEntry{promote, ban}
+			},
+		},
+		{
+			name: "ban after demotion",
+			events: func(t *testing.T) []moderationEntry {
+				promote := newTestEntry(t, owner, ModerationPromote, mod)
+				demote := newTestEntry(t, owner, ModerationDemote, mod, promote.id)
+				ban := newTestEntry(t, mod, ModerationBan, member, demote.id)
+				return []moderationEntry{promote, demote, ban}
+			},
+		},
+		{
+			// A demoted moderator names only events from before the demotion and claims an earlier time
+			name: "backdated ban after demotion",
+			events: func(t *testing.T) []moderationEntry {
+				promote := newTestEntry(t, owner, ModerationPromote, mod)
+				demote := newTestEntry(t, owner, ModerationDemote, mod, promote.id)
+				ban := newTestEntry(t, mod, ModerationBan, member, promote.id)
+				ban.outer.Timestamp = promote.outer.Timestamp - 1
+				return []moderationEntry{promote, demote, ban}
+			},
+		},
+		{
+			name: "ban the owner saw before demoting",
+			events: func(t *testing.T) []moderationEntry {
+				promote := newTestEntry(t, owner, ModerationPromote, mod)
+				ban := newTestEntry(t, mod, ModerationBan, member, promote.id)
+				demote := newTestEntry(t, owner, ModerationDemote, mod, ban.id)
+				return []moderationEntry{promote, ban, demote}
+			},
+			wantBanned: true,
+		},
+		{
+			name: "ban before a concurrent promotion of the target",
+			events: func(t *testing.T) []moderationEntry {
+				promote := newTestEntry(t, owner, ModerationPromote, mod)
+				ban := newTestEntry(t, mod, ModerationBan, member, promote.id)
+				promoteMember := newTestEntry(t, owner, ModerationPromote, member, promote.id)
+				return []moderationEntry{promote, ban, promoteMember}
+			},
+		},
+		{
+			name: "unban by another moderator",
+			events: func(t *testing.T) []moderationEntry {
+				promote := newTestEntry(t, owner, ModerationPromote, mod)
+				promoteOther := newTestEntry(t, owner, ModerationPromote, otherMod, promote.id)
+				ban := newTestEntry(t, mod, ModerationBan, member, promoteOther.id)
+				unban := newTestEntry(t, otherMod, ModerationUnban, member, ban.id)
+				return []moderationEntry{promote, promoteOther, ban, unban}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := tt.events(t)
+
+			// Members may receive events in any order, synced ones especially, and must end up agreeing
+			orders := map[string][]moderationEntry{"in order": events}
+			reversed := make([]moderationEntry, len(events))
+			for i, entry := range events {
+				reversed[len(events)-1-i] = entry
+			}
+			orders["reversed"] = reversed
+
+			for order, entries := range orders {
+				m := newModeration(owner)
+				for _, entry := range entries {
+					m.add(entry)
+				}
+				if got := m.isBanned(member, time.Now()); got != tt.wantBanned {
+					t.Errorf("%s: isBanned() = %v, want %v", order, got, tt.wantBanned)
+				}
+			}
+		})
+	}
+}
+
+func TestRoomOwner(t *testing.T) {
+	owner := newTestPeer(t)
+	other := newTestPeer(t)
+
+	tests := []struct {
+		name     string
+		roomName string
+		want     peer.ID
+	}{
+		{name: "owned room", roomName: OwnedRoomName("chat", owner), want: owner},
+		{name: "plain room", roomName: NormalizeRoomName("chat")},
+		{name: "not a peer ID", roomName: NormalizeRoomName("me@home")},
+		{name: "owned twice", roomName: OwnedRoomName(OwnedRoomName("chat", other), owner), want: owner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := common.RoomOwner(tt.roomName); got != tt.want {
+				t.Errorf("RoomOwner(%q) = %q, want %q", tt.roomName, got, tt.want)
+			}
+		})
+	}
+
+	if name := OwnedRoomName("chat", owner); OwnedRoomName(name, owner) != name {
+		t.Errorf("OwnedRoomName() qualified %q with its own owner again", name)
+	}
+}
@@ -0,0 +1,258 @@
+package blue_otter_client
+
+// presence.go contains all functions related to presence heartbeats and the member roster of a room
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+const (
+	// presenceInterval is how often a heartbeat is published to every joined room
+	presenceInterval = 15 * time.Second
+	// presenceTimeout is how long a member may go without a heartbeat before it is marked as timed out
+	presenceTimeout = 3 * presenceInterval
+	// presenceExpiry is how long a timed out member that is no longer subscribed stays on the roster
+	presenceExpiry = 10 * time.Minute
+	// maxStatusLength caps the length of a status published in heartbeats, in characters
+	maxStatusLength = 64
+)
+
+// Presence statuses published by this client
+const (
+	StatusOnline = "online"
+	StatusAway   = "away"
+)
+
+// Member is a single entry of a room roster
+type Member struct {
+	PeerID   peer.ID   `json:"peer_id"`
+	Username string    `json:"username,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+	TimedOut bool      `json:"timed_out,omitempty"`
+	Self     bool      `json:"self,omitempty"`
+}
+
+func (m Member) String() string {
+	switch {
+	case m.Username == "":
+		return fmt.Sprintf("✓%s (no heartbeat yet)", Fingerprint(m.PeerID))
+	case m.TimedOut:
+		return fmt.Sprintf("%s ✓%s (timed out, last seen %s)", m.Username, Fingerprint(m.PeerID), m.LastSeen.Format("15:04"))
+	default:
+		return fmt.Sprintf("%s ✓%s (%s)", m.Username, Fingerprint(m.PeerID), m.Status)
+	}
+}
+
+// roster tracks the heartbeats received in a room
+type roster struct {
+	mu      sync.Mutex
+	self    peer.ID
+	members map[peer.ID]*Member
+	// lastKey describes the roster last reported in a RosterEvent, to only report changes
+	lastKey string
+}
+
+func newRoster(self peer.ID) *roster {
+	return &roster{self: self, members: make(map[peer.ID]*Member)}
+}
+
+// observe records a heartbeat and reports whether the member is new or changed its username
+func (r *roster) observe(from peer.ID, p common.Presence, at time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, found := r.members[from]
+	if !found {
+		m = &Member{PeerID: from, Self: from == r.self}
+		r.members[from] = m
+	}
+	renamed := m.Username != p.Username
+	m.Username = p.Username
+	m.Status = p.Status
+	m.LastSeen = at
+	return renamed
+}
+
+// remove drops a member that announced it left the room
+func (r *roster) remove(id peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.members, id)
+}
+
+// username returns the username a member last published, or an empty string when it never sent a heartbeat
+func (r *roster) username(id peer.ID) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, found := r.members[id]; found {
+		return m.Username
+	}
+	return ""
+}
+
+// snapshot combines the heartbeats received with the peers currently subscribed to the room topic. Subscribed peers
+// that have not sent a heartbeat yet are only listed when listSilent is set.
+func (r *roster) snapshot(subscribed []peer.ID, listSilent bool, now time.Time) []Member {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	isSubscribed := make(map[peer.ID]bool, len(subscribed))
+	for _, id := range subscribed {
+		isSubscribed[id] = true
+	}
+
+	var members []Member
+	for id, m := range r.members {
+		silent := now.Sub(m.LastSeen)
+		if silent > presenceExpiry && !isSubscribed[id] && !m.Self {
+			delete(r.members, id)
+			continue
+		}
+		member := *m
+		member.TimedOut = !m.Self && silent > presenceTimeout
+		members = append(members, member)
+	}
+
+	// Subscribed peers that have not sent a heartbeat yet still count as present
+	for _, id := range subscribed {
+		if _, found := r.members[id]; !found && listSilent {
+			members = append(members, Member{PeerID: id})
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].TimedOut != members[j].TimedOut {
+			return !members[i].TimedOut
+		}
+		if members[i].Username != members[j].Username {
+			return members[i].Username < members[j].Username
+		}
+		return members[i].PeerID < members[j].PeerID
+	})
+
+	return members
+}
+
+// changed reports whether members differ from the roster last reported, and remembers them
+func (r *roster) changed(members []Member) bool {
+	var key strings.Builder
+	for _, m := range members {
+		fmt.Fprintf(&key, "%s|%s|%s|%t;", m.PeerID, m.Username, m.Status, m.TimedOut)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key.String() == r.lastKey {
+		return false
+	}
+	r.lastKey = key.String()
+	return true
+}
+
+// Members returns the roster of the room: every member that sent a heartbeat, timed out or not,
+// and every subscribed peer that has not sent one yet
+func (r *Room) Members() []Member {
+	// Anyone can subscribe to an invite-only room, but only members can send a heartbeat in it
+	return r.roster.snapshot(r.topic.ListPeers(), !r.inviteOnly, time.Now())
+}
+
+// Status returns the status published in our heartbeats
+func (s *Session) Status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+// SetStatus changes the status published in our heartbeats and announces it to every joined room right away
+func (s *Session) SetStatus(status string) error {
+	status = strings.TrimSpace(status)
+	if status == "" {
+		return fmt.Errorf("status must not be empty")
+	}
+	if utf8.RuneCountInString(status) > maxStatusLength {
+		return fmt.Errorf("status must be at most %d characters", maxStatusLength)
+	}
+
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+
+	for _, name := range s.RoomNames() {
+		if room, found := s.Room(name); found {
+			s.publishPresence(room)
+		}
+	}
+	return nil
+}
+
+// publishPresence publishes a signed heartbeat with our username and status to a room
+func (s *Session) publishPresence(room *Room) {
+	heartbeat := common.Presence{Username: s.username, Status: s.Status()}
+	if err := PublishMessage(s.ctx, s.Host, room.topic, room.key, common.KindPresence, heartbeat); err != nil {
+		s.events.HandleEvent(logEvent(room.Name, "Failed to publish presence: %v", err))
+	}
+}
+
+// reportRoster emits a RosterEvent when the roster of a room changed since it was last reported
+func (s *Session) reportRoster(room *Room) {
+	members := room.Members()
+	if room.roster.changed(members) {
+		s.events.HandleEvent(RosterEvent{Room: room.Name, Members: members})
+	}
+}
+
+// observePresence records a heartbeat received in a room
+func (s *Session) observePresence(room *Room, from peer.ID, p common.Presence) {
+	if p.Username == "" {
+		return
+	}
+	p.Status = truncateStatus(p.Status)
+
+	if room.roster.observe(from, p, time.Now()) && from != s.Host.ID() {
+		if owner, clash := s.identities.Observe(p.Username, from); clash {
+			s.events.HandleEvent(logEvent("Security", "Possible impersonation: %s is also used by %s (first seen from %s)", p.Username, from, owner))
+		}
+	}
+	s.reportRoster(room)
+}
+
+// truncateStatus cuts a status received from another member down to maxStatusLength characters, never splitting a
+// multi-byte character
+func truncateStatus(status string) string {
+	status = strings.ToValidUTF8(status, "")
+	if utf8.RuneCountInString(status) <= maxStatusLength {
+		return status
+	}
+	return string([]rune(status)[:maxStatusLength])
+}
+
+// presenceLoop publishes our heartbeat to a room and marks members whose heartbeats stopped as timed out
+func (s *Session) presenceLoop(ctx context.Context, room *Room) {
+	ticker := time.NewTicker(presenceInterval)
+	defer ticker.Stop()
+
+	for {
+		s.publishPresence(room)
+		s.reportRoster(room)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,609 @@
+package blue_otter_client
+
+// rooms.go contains all functions related to joining, leaving and switching between rooms in a session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	gater "github.com/patrickma6199/blue-otter/internal/blue_otter_gater"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+// RoomPrefix is the prefix every room topic name carries
+const RoomPrefix = "--blue-otter-"
+
+const (
+	// sendPollInterval is how often SendOnce checks whether a room member has been found
+	sendPollInterval = 250 * time.Millisecond
+	// sendFlushDelay is how long SendOnce waits after publishing before tearing the topic down
+	sendFlushDelay = 1 * time.Second
+	// maxSeenMessages bounds how many message IDs a room remembers to drop duplicates. A message arriving both live
+	// and through a history sync does so within moments, so only the most recent IDs are kept.
+	maxSeenMessages = 4096
+)
+
+var (
+	// ErrNotInRoom is returned when acting on a room the session has not joined
+	ErrNotInRoom = errors.New("not in room")
+	// ErrAlreadyInRoom is returned when joining a room twice
+	ErrAlreadyInRoom = errors.New("already in room")
+	// ErrNoRoomKey is returned when a sealed message arrives in a room joined without a secret
+	ErrNoRoomKey = errors.New("encrypted: no room secret configured")
+	// ErrUnsealedMessage is returned when a plaintext message arrives in an encrypted room
+	ErrUnsealedMessage = errors.New("unencrypted message in encrypted room")
+	// ErrNoRoomPeers is returned when no room member could be reached in time
+	ErrNoRoomPeers = errors.New("no room peers reachable")
+)
+
+// NormalizeRoomName adds the required topic prefix to a room name
+func NormalizeRoomName(roomName string) string {
+	if strings.HasPrefix(roomName, RoomPrefix) {
+		return roomName
+	}
+	return RoomPrefix + roomName
+}
+
+// Room is a single room topic joined by a session
+type Room struct {
+	Name       string
+	key        *common.RoomKey
+	topic      *pubsub.Topic
+	sub        *pubsub.Subscription
+	cancel     context.CancelFunc
+	roster     *roster
+	moderation *moderation
+	inviteOnly bool
+	joined     time.Time
+
+	mu            sync.Mutex
+	historyBefore int64
+	seen          map[string]struct{}
+	// seenOrder holds the IDs in seen in the order they were marked, oldest at seenNext once it is full
+	seenOrder []string
+	seenNext  int
+}
+
+// Encrypted reports whether messages in the room are sealed with a room key
+func (r *Room) Encrypted() bool {
+	return r.key != nil
+}
+
+// InviteOnly reports whether the room can only be joined with an invitation
+func (r *Room) InviteOnly() bool {
+	return r.inviteOnly
+}
+
+// Peers returns the peers currently subscribed to the room topic
+func (r *Room) Peers() []peer.ID {
+	return r.topic.ListPeers()
+}
+
+// markSeen records a message ID and reports whether it had not been seen before. Past maxSeenMessages the oldest
+// ID is forgotten.
+func (r *Room) markSeen(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, found := r.seen[id]; found {
+		return false
+	}
+
+	if len(r.seenOrder) < maxSeenMessages {
+		r.seenOrder = append(r.seenOrder, id)
+	} else {
+		delete(r.seen, r.seenOrder[r.seenNext])
+		r.seenOrder[r.seenNext] = id
+		r.seenNext = (r.seenNext + 1) % maxSeenMessages
+	}
+	r.seen[id] = struct{}{}
+	return true
+}
+
+// Session is a client connection to the mesh: one host and GossipSub router shared by every joined room
+type Session struct {
+	ctx             context.Context
+	cancel          context.CancelFunc
+	Host            host.Host
+	DirectMessenger *DirectMessenger
+	Gater           *gater.Gater
+
+	username    string
+	ps          *pubsub.PubSub
+	dht         *dht.IpfsDHT
+	discovery   *peerDiscovery
+	resources   *common.HostResources
+	identities  *identityBook
+	events      EventSink
+	historySize int
+
+	mu      sync.Mutex
+	status  string
+	rooms   map[string]*Room
+	current string
+	// stopDirectory stops advertising us in the room directory, nil while we are not in any public room
+	stopDirectory context.CancelFunc
+}
+
+// Close stops the background work of the session and closes its DHT and host
+func (s *Session) Close() error {
+	s.cancel()
+	s.dht.Close()
+	return s.Host.Close()
+}
+
+// ResourceUsage reports the connections, streams, memory and file descriptors the session uses against its limits
+func (s *Session) ResourceUsage() common.ResourceUsage {
+	return s.resources.Usage(s.Host)
+}
+
+// Username returns the name the session publishes under
+func (s *Session) Username() string {
+	return s.username
+}
+
+// Join subscribes to a room and announces our arrival. The first joined room becomes the active one.
+func (s *Session) Join(roomName string, roomKey *common.RoomKey) (*Room, error) {
+	s.mu.Lock()
+	if _, found := s.rooms[roomName]; found {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrAlreadyInRoom, roomName)
+	}
+
+	// Rooms we were invited to are always joined with the key from the invitation
+	inviteKey, err := inviteRoomKey(roomName)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to load invitation for %s: %w", roomName, err)
+	}
+	if inviteKey != nil {
+		roomKey = inviteKey
+	}
+
+	room := &Room{
+		Name:       roomName,
+		key:        roomKey,
+		roster:     newRoster(s.Host.ID()),
+		moderation: newModeration(common.RoomOwner(roomName)),
+		inviteOnly: inviteKey != nil,
+		joined:     time.Now(),
+
+		historyBefore: management.HistoryEnd,
+		seen:          make(map[string]struct{}),
+	}
+
+	// Bans and mutes from earlier sessions apply before the first message arrives
+	if err := s.loadModeration(room); err != nil {
+		s.events.HandleEvent(logEvent("Moderation", "Warning: Failed to load moderation log for %s: %v", roomName, err))
+	}
+	if room.moderation.isBanned(s.Host.ID(), time.Now()) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrBanned, roomName)
+	}
+
+	if err := s.ps.RegisterTopicValidator(roomName, s.roomValidator(room)); err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to register validator for %s: %w", roomName, err)
+	}
+
+	topic, err := s.ps.Join(roomName)
+	if err != nil {
+		s.ps.UnregisterTopicValidator(roomName)
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to join topic %s: %w", roomName, err)
+	}
+
+	if err := topic.SetScoreParams(roomScoreParams()); err != nil {
+		topic.Close()
+		s.ps.UnregisterTopicValidator(roomName)
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to enable peer scoring in %s: %w", roomName, err)
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		s.ps.UnregisterTopicValidator(roomName)
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", roomName, err)
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	room.topic = topic
+	room.sub = sub
+	room.cancel = cancel
+	s.rooms[roomName] = room
+	if s.current == "" {
+		s.current = roomName
+	}
+	s.updateDirectoryLocked()
+	s.mu.Unlock()
+
+	// Show the most recent local history before any live messages arrive
+	messages, err := s.ScrollBack(roomName, s.historySize)
+	if err != nil {
+		s.events.HandleEvent(logEvent("History", "Warning: Failed to load history for %s: %v", roomName, err))
+	}
+	if len(messages) > 0 {
+		s.events.HandleEvent(HistoryEvent{Room: roomName, Messages: messages})
+	}
+
+	go s.receive(ctx, room)
+	go s.syncRoom(ctx, room)
+	go s.presenceLoop(ctx, room)
+	go s.discovery.advertise(ctx, RoomNamespace(roomName))
+	go s.discovery.discoverRoom(ctx, roomName, topic)
+
+	joinMsg := common.SystemNotification{
+		Type:    "join",
+		Message: fmt.Sprintf("[%s] User %s has joined the room", roomName, s.username),
+	}
+	if err := PublishMessage(s.ctx, s.Host, topic, roomKey, common.KindNotification, joinMsg); err != nil {
+		s.events.HandleEvent(logEvent(roomName, "Failed to announce join: %v", err))
+	}
+
+	if room.inviteOnly {
+		s.events.HandleEvent(logEvent(roomName, "This room is invite-only, messages from anyone without an invitation are dropped"))
+	}
+
+	return room, nil
+}
+
+// syncRoom catches up on what happened in a room while we were away. Moderation is synced first so that
+// history from banned and muted members can be left out.
+func (s *Session) syncRoom(ctx context.Context, room *Room) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(historySyncDelay):
+	}
+
+	s.syncModeration(ctx, room)
+	if ctx.Err() != nil {
+		return
+	}
+	s.syncHistory(ctx, room)
+}
+
+// Part announces our departure from a room and unsubscribes from it
+func (s *Session) Part(roomName string) error {
+	s.mu.Lock()
+	room, found := s.rooms[roomName]
+	if !found {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotInRoom, roomName)
+	}
+	delete(s.rooms, roomName)
+	if s.current == roomName {
+		s.current = ""
+		for _, name := range s.roomNamesLocked() {
+			s.current = name
+			break
+		}
+	}
+	s.updateDirectoryLocked()
+	s.mu.Unlock()
+
+	leaveMsg := common.SystemNotification{
+		Type:    "leave",
+		Message: fmt.Sprintf("[%s] User %s has left the room", roomName, s.username),
+	}
+	PublishMessage(s.ctx, s.Host, room.topic, room.key, common.KindNotification, leaveMsg)
+
+	room.cancel()
+	room.sub.Cancel()
+	s.ps.UnregisterTopicValidator(roomName)
+	return room.topic.Close()
+}
+
+// updateDirectoryLocked advertises us in the room directory while we are in at least one public room, with a
+// single advertisement for the whole session however many public rooms are joined
+func (s *Session) updateDirectoryLocked() {
+	public := false
+	for _, room := range s.rooms {
+		if !room.Encrypted() {
+			public = true
+			break
+		}
+	}
+
+	switch {
+	case public && s.stopDirectory == nil:
+		ctx, cancel := context.WithCancel(s.ctx)
+		s.stopDirectory = cancel
+		go s.discovery.advertise(ctx, DirectoryNamespace)
+	case !public && s.stopDirectory != nil:
+		s.stopDirectory()
+		s.stopDirectory = nil
+	}
+}
+
+// PartAll leaves every joined room
+func (s *Session) PartAll() {
+	for _, name := range s.RoomNames() {
+		s.Part(name)
+	}
+}
+
+// Switch makes a joined room the active one
+func (s *Session) Switch(roomName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.rooms[roomName]; !found {
+		return fmt.Errorf("%w: %s", ErrNotInRoom, roomName)
+	}
+
+	s.current = roomName
+	return nil
+}
+
+// Current returns the active room, or nil when no room is joined
+func (s *Session) Current() *Room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rooms[s.current]
+}
+
+// Room returns a joined room by name
+func (s *Session) Room(roomName string) (*Room, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, found := s.rooms[roomName]
+	return room, found
+}
+
+// RoomNames returns the names of all joined rooms in sorted order
+func (s *Session) RoomNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.roomNamesLocked()
+}
+
+func (s *Session) roomNamesLocked() []string {
+	names := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Send publishes a chat message to a joined room
+func (s *Session) Send(ctx context.Context, roomName string, text string) error {
+	room, found := s.Room(roomName)
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNotInRoom, roomName)
+	}
+
+	msg := common.ChatMessage{Sender: s.username, Text: text}
+	return PublishMessage(ctx, s.Host, room.topic, room.key, common.KindChat, msg)
+}
+
+// openEnvelope decrypts, authenticates and decodes an envelope that from published in room
+func (s *Session) openEnvelope(room *Room, env common.Envelope, from peer.ID) (common.Envelope, any, error) {
+	switch {
+	case env.Kind == common.KindSealed && room.key == nil:
+		return env, nil, ErrNoRoomKey
+	case env.Kind == common.KindSealed:
+		inner, err := room.key.Open(env)
+		if err != nil {
+			return env, nil, err
+		}
+		env = inner
+	case room.key != nil:
+		return env, nil, ErrUnsealedMessage
+	}
+
+	if err := VerifySender(env, from); err != nil {
+		return env, nil, err
+	}
+
+	payload, err := env.DecodePayload()
+	if err != nil {
+		return env, nil, err
+	}
+
+	return env, payload, nil
+}
+
+// SendOnce publishes a single chat message to a room without subscribing to it or announcing a join.
+// It blocks until at least one room member is reachable, or fails with ErrNoRoomPeers when ctx expires first.
+func (s *Session) SendOnce(ctx context.Context, roomName string, roomKey *common.RoomKey, text string) error {
+	inviteKey, err := inviteRoomKey(roomName)
+	if err != nil {
+		return fmt.Errorf("failed to load invitation for %s: %w", roomName, err)
+	}
+	if inviteKey != nil {
+		roomKey = inviteKey
+	}
+
+	topic, err := s.ps.Join(roomName)
+	if err != nil {
+		return fmt.Errorf("failed to join topic %s: %w", roomName, err)
+	}
+	defer topic.Close()
+
+	// Room members are found under the room's namespace, we only look without advertising ourselves
+	discoverCtx, stopDiscovery := context.WithCancel(ctx)
+	defer stopDiscovery()
+	go s.discovery.discoverRoom(discoverCtx, roomName, topic)
+
+	// Without a subscription there is no mesh, so wait until a member's subscription is known and publish through fanout
+	ticker := time.NewTicker(sendPollInterval)
+	defer ticker.Stop()
+	for len(topic.ListPeers()) == 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrNoRoomPeers, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	msg := common.ChatMessage{Sender: s.username, Text: text}
+	if err := PublishMessage(ctx, s.Host, topic, roomKey, common.KindChat, msg); err != nil {
+		return err
+	}
+
+	// Publish only queues the message, so give the router a moment to push it out before the topic goes away
+	time.Sleep(sendFlushDelay)
+	return nil
+}
+
+func (s *Session) receive(ctx context.Context, room *Room) {
+	roomName := room.Name
+
+	for {
+		msg, err := room.sub.Next(ctx)
+		if err != nil {
+			return
+		}
+
+		outer, err := common.DecodeEnvelope(msg.Data)
+		if err != nil {
+			s.events.HandleEvent(UnreadableEvent{Room: roomName, PeerID: msg.ReceivedFrom, Reason: err, Data: msg.Data})
+			continue
+		}
+
+		from := msg.GetFrom()
+		// Denied peers can no longer connect to us, but their messages may still be relayed by other members
+		if s.Gater.PeerDenied(from) {
+			continue
+		}
+		// The validator already drops these, but a ban may arrive while earlier messages are queued
+		if room.moderation.isBanned(from, time.Now()) {
+			continue
+		}
+
+		env, payload, err := s.openEnvelope(room, outer, from)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrNoRoomKey), errors.Is(err, common.ErrUndecryptable):
+			s.events.HandleEvent(UnreadableEvent{Room: roomName, PeerID: from, Reason: err})
+			continue
+		case errors.Is(err, ErrUnsealedMessage):
+			s.events.HandleEvent(logEvent("Security", "Ignoring unencrypted %s message from %s in encrypted room", env.Kind, from))
+			continue
+		case errors.Is(err, ErrSenderMismatch), errors.Is(err, common.ErrMissingSignature), errors.Is(err, common.ErrInvalidSignature):
+			s.events.HandleEvent(logEvent("Security", "Dropped %s message from %s: %v", env.Kind, from, err))
+			continue
+		case errors.Is(err, common.ErrUnknownKind):
+			s.events.HandleEvent(logEvent(roomName+" | protocol", "Ignoring %q message (v%d) from %s: not supported by this client", env.Kind, env.Version, msg.ReceivedFrom))
+			continue
+		default:
+			s.events.HandleEvent(logEvent(roomName+" | protocol", "Dropping message from %s: %v", msg.ReceivedFrom, err))
+			continue
+		}
+
+		// The same message may already have arrived through a history sync. Only verified messages are marked, so a
+		// forgery reusing the ID of a real message cannot hide it.
+		if !room.markSeen(env.ID) {
+			continue
+		}
+
+		switch p := payload.(type) {
+		case common.ChatMessage:
+			if room.moderation.isMuted(from, time.Now()) {
+				continue
+			}
+			if p.Sender != "" && p.Text != "" {
+				if owner, clash := s.identities.Observe(p.Sender, from); clash {
+					s.events.HandleEvent(logEvent("Security", "Possible impersonation: %s is also used by %s (first seen from %s)", p.Sender, from, owner))
+				}
+				s.events.HandleEvent(MessageEvent{
+					Room:      roomName,
+					ID:        env.ID,
+					PeerID:    from.String(),
+					Sender:    p.Sender,
+					Text:      p.Text,
+					Timestamp: env.Timestamp,
+				})
+
+				if err := s.saveHistory(room, outer); err != nil {
+					s.events.HandleEvent(logEvent("History", "Warning: Failed to save message: %v", err))
+				}
+			}
+		case common.SystemNotification:
+			s.events.HandleEvent(NotificationEvent{Room: roomName, PeerID: from, Type: p.Type, Message: p.Message})
+			if p.Type == "leave" {
+				room.roster.remove(from)
+				s.reportRoster(room)
+			}
+		case common.Presence:
+			s.observePresence(room, from, p)
+		case common.Moderation:
+			s.observeModeration(room, env, outer, from, p)
+		}
+	}
+}
+
+// saveHistory appends a message to the log of a room. The room stays locked while the log may be compacted, so
+// scrolling back never reads the new log at an offset into the old one.
+func (s *Session) saveHistory(room *Room, env common.Envelope) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	dropped, err := management.AppendHistory(room.Name, env)
+	if dropped > 0 && room.historyBefore != management.HistoryEnd {
+		room.historyBefore = max(room.historyBefore-dropped, 0)
+	}
+	return err
+}
+
+// ScrollBack returns up to n chat messages from the room's local history that are older than
+// anything returned so far, oldest first. The first call pages back from the end of the history as it was then, so
+// messages stored later are never returned.
+func (s *Session) ScrollBack(roomName string, n int) ([]MessageEvent, error) {
+	room, found := s.Room(roomName)
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNotInRoom, roomName)
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	envelopes, before, err := management.LoadHistoryPage(roomName, room.historyBefore, n)
+	if err != nil {
+		return nil, err
+	}
+	room.historyBefore = before
+
+	var messages []MessageEvent
+	for _, outer := range envelopes {
+		from, err := peer.Decode(outer.Sender)
+		if err != nil {
+			continue
+		}
+
+		env, payload, err := s.openEnvelope(room, outer, from)
+		if err != nil {
+			continue
+		}
+
+		if p, ok := payload.(common.ChatMessage); ok {
+			messages = append(messages, MessageEvent{
+				Room:      roomName,
+				ID:        env.ID,
+				PeerID:    from.String(),
+				Sender:    p.Sender,
+				Text:      p.Text,
+				Timestamp: env.Timestamp,
+			})
+		}
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,183 @@
+package blue_otter_client
+
+// supervisor.go contains the supervisor that keeps the client connected to its bootstrap nodes and the DHT
+
+import (
+	"context"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+// Network states reported by NetworkStatusEvent
+const (
+	// NetworkOffline means we are not connected to any peer
+	NetworkOffline = "offline"
+	// NetworkDegraded means we have peers but no bootstrap node or an empty routing table, so the DHT cannot be used
+	NetworkDegraded = "degraded"
+	// NetworkOnline means a bootstrap node is connected, when any are saved, and the routing table has peers
+	NetworkOnline = "online"
+)
+
+const (
+	// superviseInterval is how often connectedness and the routing table are checked
+	superviseInterval = 5 * time.Second
+	// redialMinBackoff is how long to wait before redialing a bootstrap node the first time it cannot be reached
+	redialMinBackoff = 5 * time.Second
+	// redialMaxBackoff caps the wait between redials, which doubles after every failure
+	redialMaxBackoff = 5 * time.Minute
+	// redialTimeout bounds a single redial
+	redialTimeout = 15 * time.Second
+)
+
+// backoff is an exponential retry schedule
+type backoff struct {
+	wait time.Duration
+	next time.Time
+	// down is set once the peer was found disconnected, until it is connected again
+	down bool
+}
+
+func (b *backoff) due(now time.Time) bool {
+	return !now.Before(b.next)
+}
+
+// fail doubles the wait and schedules the next attempt, returning the wait
+func (b *backoff) fail(now time.Time) time.Duration {
+	if b.wait == 0 {
+		b.wait = redialMinBackoff
+	} else if b.wait *= 2; b.wait > redialMaxBackoff {
+		b.wait = redialMaxBackoff
+	}
+	b.next = now.Add(b.wait)
+	return b.wait
+}
+
+func (b *backoff) reset() {
+	*b = backoff{}
+}
+
+// supervisor redials bootstrap nodes that are down or dropped, refreshes the routing table when it empties, and
+// reports every change in network status
+type supervisor struct {
+	host       host.Host
+	dht        *dht.IpfsDHT
+	bootstraps []peer.AddrInfo
+	private    bool
+	events     EventSink
+
+	redials map[peer.ID]*backoff
+	refresh backoff
+	status  string
+}
+
+func newSupervisor(host host.Host, kDht *dht.IpfsDHT, bootstraps []peer.AddrInfo, private bool, events EventSink) *supervisor {
+	redials := make(map[peer.ID]*backoff)
+	for _, info := range bootstraps {
+		redials[info.ID] = &backoff{down: host.Network().Connectedness(info.ID) != network.Connected}
+	}
+	return &supervisor{
+		host:       host,
+		dht:        kDht,
+		bootstraps: bootstraps,
+		private:    private,
+		events:     events,
+		redials:    redials,
+	}
+}
+
+// run checks the network every superviseInterval until ctx is done
+func (s *supervisor) run(ctx context.Context) {
+	for {
+		s.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(superviseInterval):
+		}
+	}
+}
+
+func (s *supervisor) check(ctx context.Context) {
+	now := time.Now()
+	reconnected := false
+	for _, info := range s.bootstraps {
+		redial := s.redials[info.ID]
+		if s.host.Network().Connectedness(info.ID) != network.Connected && redial.due(now) {
+			dialCtx, cancel := context.WithTimeout(ctx, redialTimeout)
+			err := s.host.Connect(dialCtx, info)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				wait := redial.fail(now)
+				redial.down = true
+				s.events.HandleEvent(logEvent("Networking", "Failed to reach bootstrap peer %s: %v (retrying in %s)", info.ID, management.ExplainHandshakeError(err, s.private), wait))
+				continue
+			}
+		}
+
+		if s.host.Network().Connectedness(info.ID) != network.Connected {
+			redial.down = true
+			continue
+		}
+		// The bootstrap node may also have dialed us since it came back
+		if redial.down {
+			reconnected = true
+			s.events.HandleEvent(logEvent("Networking", "Reconnected to bootstrap: %s", info.ID))
+		}
+		redial.reset()
+	}
+
+	// A reconnected bootstrap node or an empty table means the routing table needs refilling
+	if reconnected || (s.dht.RoutingTable().Size() == 0 && len(s.host.Network().Peers()) > 0) {
+		if reconnected {
+			s.refresh.reset()
+		}
+		if s.refresh.due(now) {
+			if err := s.dht.Bootstrap(ctx); err != nil {
+				s.events.HandleEvent(logEvent("Networking", "Failed to refresh routing table: %v", err))
+			}
+			s.refresh.fail(now)
+		}
+	} else if s.dht.RoutingTable().Size() > 0 {
+		s.refresh.reset()
+	}
+
+	s.report()
+}
+
+// report emits a NetworkStatusEvent when the network status changed since the last check
+func (s *supervisor) report() {
+	ev := NetworkStatusEvent{
+		Bootstraps:   len(s.bootstraps),
+		RoutingTable: s.dht.RoutingTable().Size(),
+		Peers:        len(s.host.Network().Peers()),
+	}
+	for _, info := range s.bootstraps {
+		if s.host.Network().Connectedness(info.ID) == network.Connected {
+			ev.ConnectedBootstraps++
+		}
+	}
+
+	switch {
+	case ev.Peers == 0:
+		ev.Status = NetworkOffline
+	case ev.RoutingTable > 0 && (ev.Bootstraps == 0 || ev.ConnectedBootstraps > 0):
+		ev.Status = NetworkOnline
+	default:
+		ev.Status = NetworkDegraded
+	}
+
+	if ev.Status == s.status {
+		return
+	}
+	s.status = ev.Status
+	s.events.HandleEvent(ev)
+}
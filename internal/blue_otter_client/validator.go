@@ -0,0 +1,329 @@
+package blue_otter_client
+
+// validator.go contains the GossipSub message validators and the peer scoring that keeps abusive peers out of room meshes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+const (
+	// rejectReportInterval is how often rejections from the same peer are reported in the system log
+	rejectReportInterval = 10 * time.Second
+	// bucketSweepInterval is how often rate limits of peers that went quiet are forgotten
+	bucketSweepInterval = time.Minute
+)
+
+var (
+	// ErrMessageTooLarge is reported when a room message is larger than MessageLimits.MaxSize
+	ErrMessageTooLarge = errors.New("message too large")
+	// ErrRateLimited is reported when a peer publishes faster than MessageLimits.Rate allows
+	ErrRateLimited = errors.New("message rate exceeded")
+)
+
+// MessageLimits bounds what a single peer may publish to a room before its messages are rejected
+type MessageLimits struct {
+	// MaxSize is the largest encoded envelope accepted, in bytes
+	MaxSize int
+	// Rate is how many messages per second each peer may publish on average
+	Rate float64
+	// Burst is how many messages each peer may publish at once before Rate applies
+	Burst int
+}
+
+// DefaultMessageLimits returns limits generous enough for people chatting and strict enough to stop floods
+func DefaultMessageLimits() MessageLimits {
+	return MessageLimits{
+		MaxSize: 64 * 1024,
+		Rate:    5,
+		Burst:   20,
+	}
+}
+
+// Validate checks that every limit is positive and that MaxSize fits in a GossipSub message
+func (l MessageLimits) Validate() error {
+	if l.MaxSize <= 0 || l.Rate <= 0 || l.Burst <= 0 {
+		return fmt.Errorf("message limits must be positive")
+	}
+	if l.MaxSize > pubsub.DefaultMaxMessageSize {
+		return fmt.Errorf("maximum message size cannot exceed %d bytes", pubsub.DefaultMaxMessageSize)
+	}
+	return nil
+}
+
+// tokenBucket allows Burst messages at once, refilling at Rate messages per second
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rejection counts the messages rejected from one peer since it was last reported
+type rejection struct {
+	reported   time.Time
+	suppressed int
+}
+
+// messageValidator checks every room message before GossipSub delivers or forwards it
+type messageValidator struct {
+	self   peer.ID
+	limits MessageLimits
+	events EventSink
+
+	mu         sync.Mutex
+	buckets    map[peer.ID]*tokenBucket
+	rejections map[peer.ID]*rejection
+	swept      time.Time
+}
+
+func newMessageValidator(self peer.ID, limits MessageLimits, events EventSink) *messageValidator {
+	return &messageValidator{
+		self:       self,
+		limits:     limits,
+		events:     events,
+		buckets:    make(map[peer.ID]*tokenBucket),
+		rejections: make(map[peer.ID]*rejection),
+		swept:      time.Now(),
+	}
+}
+
+// Validate is registered as the default GossipSub validator. Rejected messages are neither delivered nor forwarded,
+// and count against the score of the peer that sent them to us.
+func (v *messageValidator) Validate(_ context.Context, receivedFrom peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	author := msg.GetFrom()
+	if author == v.self {
+		return pubsub.ValidationAccept
+	}
+
+	if len(msg.Data) > v.limits.MaxSize {
+		return v.reject(receivedFrom, author, msg, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(msg.Data)))
+	}
+
+	env, err := common.DecodeEnvelope(msg.Data)
+	if err != nil {
+		return v.reject(receivedFrom, author, msg, err)
+	}
+
+	// Sealed envelopes can only be opened with the room key, their inner signature is checked on receipt
+	if env.Kind == common.KindSealed {
+		if env.Sender != author.String() {
+			return v.reject(receivedFrom, author, msg, fmt.Errorf("%w: claims %s, published by %s", ErrSenderMismatch, env.Sender, author))
+		}
+	} else if err := VerifySender(env, author); err != nil {
+		return v.reject(receivedFrom, author, msg, err)
+	}
+
+	if !v.allow(author) {
+		// Only the flooding peer itself is penalised, members relaying its messages are not at fault
+		if receivedFrom != author {
+			v.report(author, msg, ErrRateLimited)
+			return pubsub.ValidationIgnore
+		}
+		return v.reject(receivedFrom, author, msg, ErrRateLimited)
+	}
+
+	return pubsub.ValidationAccept
+}
+
+// allow takes a token from the author's bucket and reports whether one was available
+func (v *messageValidator) allow(author peer.ID) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	v.sweep(now)
+
+	bucket, found := v.buckets[author]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(v.limits.Burst), last: now}
+		v.buckets[author] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * v.limits.Rate
+	if bucket.tokens > float64(v.limits.Burst) {
+		bucket.tokens = float64(v.limits.Burst)
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// sweep forgets buckets that have refilled completely, the peer would start from a full bucket anyway
+func (v *messageValidator) sweep(now time.Time) {
+	if now.Sub(v.swept) < bucketSweepInterval {
+		return
+	}
+	v.swept = now
+
+	full := time.Duration(float64(v.limits.Burst) / v.limits.Rate * float64(time.Second))
+	for id, bucket := range v.buckets {
+		if now.Sub(bucket.last) > full {
+			delete(v.buckets, id)
+		}
+	}
+	for id, r := range v.rejections {
+		if now.Sub(r.reported) > rejectReportInterval {
+			delete(v.rejections, id)
+		}
+	}
+}
+
+func (v *messageValidator) reject(receivedFrom peer.ID, author peer.ID, msg *pubsub.Message, reason error) pubsub.ValidationResult {
+	if receivedFrom != author {
+		reason = fmt.Errorf("%w (relayed by %s)", reason, receivedFrom)
+	}
+	v.report(author, msg, reason)
+	return pubsub.ValidationReject
+}
+
+// report logs a rejected message, summarising further rejections from the same peer so a flood cannot flood the log too
+func (v *messageValidator) report(author peer.ID, msg *pubsub.Message, reason error) {
+	v.mu.Lock()
+	now := time.Now()
+	r, found := v.rejections[author]
+	if found && now.Sub(r.reported) < rejectReportInterval {
+		r.suppressed++
+		v.mu.Unlock()
+		return
+	}
+	suppressed := 0
+	if found {
+		suppressed = r.suppressed
+	}
+	v.rejections[author] = &rejection{reported: now}
+	v.mu.Unlock()
+
+	line := fmt.Sprintf("Rejected message from %s in %s: %v", author, msg.GetTopic(), reason)
+	if suppressed > 0 {
+		line += fmt.Sprintf(" (%d more rejected since last report)", suppressed)
+	}
+	v.events.HandleEvent(LogEvent{Category: "Security", Message: line})
+}
+
+// Peer score thresholds. Each rejected message costs a peer more than the last, so a few rejections stop us gossiping
+// with it, more stop us publishing to it and a sustained flood gets everything it sends ignored.
+const (
+	gossipThreshold             = -100
+	publishThreshold            = -500
+	graylistThreshold           = -1000
+	acceptPXThreshold           = 10
+	opportunisticGraftThreshold = 5
+)
+
+// peerScoreParams returns the router-wide scoring parameters. Topic parameters are added per room by roomScoreParams.
+func peerScoreParams() (*pubsub.PeerScoreParams, *pubsub.PeerScoreThresholds) {
+	params := &pubsub.PeerScoreParams{
+		Topics:           make(map[string]*pubsub.TopicScoreParams),
+		AppSpecificScore: func(peer.ID) float64 { return 0 },
+
+		// Peers that spam GRAFTs or break gossip promises are penalised too
+		BehaviourPenaltyWeight:    -10,
+		BehaviourPenaltyThreshold: 6,
+		BehaviourPenaltyDecay:     pubsub.ScoreParameterDecay(10 * time.Minute),
+
+		DecayInterval: pubsub.DefaultDecayInterval,
+		DecayToZero:   pubsub.DefaultDecayToZero,
+		// A pruned peer cannot reset its score by reconnecting
+		RetainScore: 30 * time.Minute,
+	}
+
+	thresholds := &pubsub.PeerScoreThresholds{
+		GossipThreshold:             gossipThreshold,
+		PublishThreshold:            publishThreshold,
+		GraylistThreshold:           graylistThreshold,
+		AcceptPXThreshold:           acceptPXThreshold,
+		OpportunisticGraftThreshold: opportunisticGraftThreshold,
+	}
+
+	return params, thresholds
+}
+
+// roomScoreParams returns the scoring parameters of a room topic. Peers earn a little for staying in the mesh and
+// for delivering messages first, and lose a lot for every message the validator rejects. A negative score gets a
+// peer pruned from the room mesh at the next heartbeat, and the penalty fades over about ten minutes.
+func roomScoreParams() *pubsub.TopicScoreParams {
+	return &pubsub.TopicScoreParams{
+		TopicWeight: 1,
+
+		TimeInMeshWeight:  0.01,
+		TimeInMeshQuantum: time.Second,
+		TimeInMeshCap:     3600,
+
+		FirstMessageDeliveriesWeight: 1,
+		FirstMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(10 * time.Minute),
+		FirstMessageDeliveriesCap:    20,
+
+		// Rooms are often quiet, so peers are not penalised for delivering too few messages
+		MeshMessageDeliveriesWeight: 0,
+
+		InvalidMessageDeliveriesWeight: -10,
+		InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(10 * time.Minute),
+	}
+}
+
+// roomValidator is registered for each joined room. In invite-only rooms it drops every message that is not sealed
+// with the room key, since only invited members hold it. It also drops messages from banned members and chat from
+// muted members. Moderation events themselves are checked on receipt, as a relaying member may not know every
+// promotion yet.
+func (s *Session) roomValidator(room *Room) pubsub.ValidatorEx {
+	return func(_ context.Context, receivedFrom peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		author := msg.GetFrom()
+		if author == s.Host.ID() {
+			return pubsub.ValidationAccept
+		}
+
+		now := time.Now()
+		if room.moderation.isBanned(author, now) {
+			return dropFrom(receivedFrom, author)
+		}
+
+		muted := room.moderation.isMuted(author, now)
+		if !room.inviteOnly && !muted {
+			return pubsub.ValidationAccept
+		}
+
+		outer, err := common.DecodeEnvelope(msg.Data)
+		if err != nil {
+			return pubsub.ValidationReject
+		}
+
+		// Sealing with the room key is the membership proof, members never relay messages without it
+		if room.inviteOnly {
+			if outer.Kind != common.KindSealed {
+				return pubsub.ValidationReject
+			}
+			if _, err := room.key.Open(outer); err != nil {
+				return pubsub.ValidationReject
+			}
+		}
+
+		if muted {
+			if _, payload, err := s.openEnvelope(room, outer, author); err == nil {
+				if _, chat := payload.(common.ChatMessage); chat {
+					return dropFrom(receivedFrom, author)
+				}
+			}
+		}
+
+		return pubsub.ValidationAccept
+	}
+}
+
+// dropFrom rejects a message sent to us by its author, so the author's score suffers, and ignores it when another
+// member relayed it before learning about the ban or mute
+func dropFrom(receivedFrom peer.ID, author peer.ID) pubsub.ValidationResult {
+	if receivedFrom == author {
+		return pubsub.ValidationReject
+	}
+	return pubsub.ValidationIgnore
+}
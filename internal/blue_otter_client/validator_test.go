@@ -0,0 +1,184 @@
+package blue_otter_client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limits := MessageLimits{MaxSize: 1024, Rate: 2, Burst: 3}
+
+	tests := []struct {
+		name string
+		// idle is how long the peer was quiet after using up its burst
+		idle time.Duration
+		want int
+	}{
+		{name: "no wait", idle: 0, want: 0},
+		{name: "half a token", idle: 250 * time.Millisecond, want: 0},
+		{name: "one token", idle: 500 * time.Millisecond, want: 1},
+		{name: "two tokens", idle: time.Second, want: 2},
+		{name: "capped at burst", idle: time.Hour, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newMessageValidator(newTestPeer(t), limits, &RecordingSink{})
+			author := newTestPeer(t)
+
+			for i := 0; i < limits.Burst; i++ {
+				if !v.allow(author) {
+					t.Fatalf("message %d of the burst was refused", i+1)
+				}
+			}
+			if v.allow(author) {
+				t.Fatal("message beyond the burst was allowed")
+			}
+
+			// Pretend the peer went quiet, a small margin covers the time the test itself takes
+			v.mu.Lock()
+			v.buckets[author].last = v.buckets[author].last.Add(-tt.idle - 10*time.Millisecond)
+			v.mu.Unlock()
+
+			got := 0
+			for v.allow(author) {
+				got++
+			}
+			if got != tt.want {
+				t.Errorf("allowed %d messages after %s, want %d", got, tt.idle, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageValidator(t *testing.T) {
+	self := newTestPeer(t)
+	authorKey, author := newTestIdentity(t)
+	relay := newTestPeer(t)
+	limits := MessageLimits{MaxSize: 512, Rate: 1, Burst: 1}
+
+	signed := func(t *testing.T, sender string, text string) []byte {
+		t.Helper()
+		env, err := common.NewEnvelope(common.KindChat, sender, common.ChatMessage{Sender: "tester", Text: text})
+		if err != nil {
+			t.Fatalf("NewEnvelope: %v", err)
+		}
+		if err := env.Sign(authorKey); err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		data, err := common.EncodeEnvelope(env)
+		if err != nil {
+			t.Fatalf("EncodeEnvelope: %v", err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name         string
+		from         peer.ID
+		receivedFrom peer.ID
+		data         func(t *testing.T) []byte
+		// flood publishes one accepted message first, using up the burst
+		flood      bool
+		want       pubsub.ValidationResult
+		wantReport string
+	}{
+		{
+			name:         "valid",
+			from:         author,
+			receivedFrom: author,
+			data:         func(t *testing.T) []byte { return signed(t, author.String(), "hello") },
+			want:         pubsub.ValidationAccept,
+		},
+		{
+			name:         "own message",
+			from:         self,
+			receivedFrom: self,
+			data:         func(t *testing.T) []byte { return []byte(strings.Repeat("x", 1024)) },
+			want:         pubsub.ValidationAccept,
+		},
+		{
+			name:         "too large",
+			from:         author,
+			receivedFrom: author,
+			data:         func(t *testing.T) []byte { return signed(t, author.String(), strings.Repeat("x", 1024)) },
+			want:         pubsub.ValidationReject,
+			wantReport:   ErrMessageTooLarge.Error(),
+		},
+		{
+			name:         "not an envelope",
+			from:         author,
+			receivedFrom: author,
+			data:         func(t *testing.T) []byte { return []byte("hello") },
+			want:         pubsub.ValidationReject,
+			wantReport:   common.ErrMalformedEnvelope.Error(),
+		},
+		{
+			name:         "sender mismatch",
+			from:         author,
+			receivedFrom: author,
+			data:         func(t *testing.T) []byte { return signed(t, relay.String(), "hello") },
+			want:         pubsub.ValidationReject,
+			wantReport:   ErrSenderMismatch.Error(),
+		},
+		{
+			name:         "flooding author",
+			from:         author,
+			receivedFrom: author,
+			data:         func(t *testing.T) []byte { return signed(t, author.String(), "hello") },
+			flood:        true,
+			want:         pubsub.ValidationReject,
+			wantReport:   ErrRateLimited.Error(),
+		},
+		{
+			// Relays are not penalised for passing on a flood they did not start
+			name:         "relayed flood",
+			from:         author,
+			receivedFrom: relay,
+			data:         func(t *testing.T) []byte { return signed(t, author.String(), "hello") },
+			flood:        true,
+			want:         pubsub.ValidationIgnore,
+			wantReport:   ErrRateLimited.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := &RecordingSink{}
+			v := newMessageValidator(self, limits, events)
+
+			message := func() *pubsub.Message {
+				topic := "room"
+				return &pubsub.Message{Message: &pb.Message{From: []byte(tt.from), Data: tt.data(t), Topic: &topic}}
+			}
+
+			if tt.flood {
+				if got := v.Validate(context.Background(), tt.receivedFrom, message()); got != pubsub.ValidationAccept {
+					t.Fatalf("first message = %v, want accepted", got)
+				}
+			}
+
+			if got := v.Validate(context.Background(), tt.receivedFrom, message()); got != tt.want {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+
+			recorded := events.Events()
+			if tt.wantReport == "" {
+				if len(recorded) != 0 {
+					t.Errorf("accepted message was reported: %v", recorded)
+				}
+				return
+			}
+			if len(recorded) != 1 || !strings.Contains(recorded[0].String(), tt.wantReport) {
+				t.Errorf("reported %v, want one report of %q", recorded, tt.wantReport)
+			}
+		})
+	}
+}
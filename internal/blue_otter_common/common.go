@@ -2,12 +2,72 @@ package common
 
 // common.go contains all custom struct types for the application
 
+import "encoding/json"
+
+// Envelope is the versioned wrapper around every message published to a room topic
+type Envelope struct {
+	Version   int             `json:"version"`
+	Kind      string          `json:"kind"`
+	ID        string          `json:"id"`
+	Sender    string          `json:"sender"`
+	Timestamp int64           `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+	Signature []byte          `json:"signature,omitempty"`
+}
+
 // ChatMessage represents a chat message in the system
 type ChatMessage struct {
 	Sender string `json:"sender"`
 	Text   string `json:"text"`
 }
 
+// Presence is the heartbeat each room member publishes periodically to show it is still around
+type Presence struct {
+	Username string `json:"username"`
+	Status   string `json:"status"`
+}
+
+// Moderation is a moderation action published to a room by its owner or one of its moderators.
+// Until is when a ban or mute expires, in Unix milliseconds, and zero when it is permanent. Parents are the IDs of the
+// latest moderation events the signer had applied, which places the action after everything it depends on.
+type Moderation struct {
+	Action  string   `json:"action"`
+	Target  string   `json:"target,omitempty"`
+	Until   int64    `json:"until,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	Parents []string `json:"parents,omitempty"`
+}
+
+// DirectMessage represents a private message sent to a single peer
+type DirectMessage struct {
+	Sender string `json:"sender"`
+	Text   string `json:"text"`
+}
+
+// SealedPayload is the payload of an envelope encrypted with a room key
+type SealedPayload struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// RoomInfo describes a public room in the room directory. Created is in Unix milliseconds.
+type RoomInfo struct {
+	Topic   string `json:"topic"`
+	Members int    `json:"members"`
+	Created int64  `json:"created,omitempty"`
+}
+
+// Invitation lets whoever holds it join an invite-only room. It carries the room key and is signed by the owner of
+// the room. Expires is in Unix milliseconds and is when clients stop accepting the token. Holding the room key is the
+// only proof of membership, so anyone who has read the key out of a token can keep using it after that.
+type Invitation struct {
+	Room      string `json:"room"`
+	Key       []byte `json:"key"`
+	Expires   int64  `json:"expires"`
+	Inviter   string `json:"inviter"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
 // BootstrapInfo represents information about bootstrap nodes
 type BootstrapInfo struct {
 	BootStrapNodeAddresses []string `json:"bootstrap_node_addresses"`
@@ -16,6 +76,12 @@ type BootstrapInfo struct {
 	PeerID     string   `json:"peer_id,omitempty"`
 }
 
+// AccessLists holds the peer IDs and CIDR ranges that may or may not connect to this node
+type AccessLists struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
 // SystemNotification represents a system notification to be displayed to the user
 type SystemNotification struct {
 	Type    string `json:"type"`
@@ -0,0 +1,15 @@
+package common
+
+// discovery.go contains the DHT protocol and the namespace every Blue Otter node advertises itself under
+
+const (
+	// DHTProtocolPrefix keeps the Blue Otter DHT apart from the public IPFS DHT
+	DHTProtocolPrefix = "/ipfs/blue-otter"
+	// DHTProtocol is the Kademlia protocol spoken under DHTProtocolPrefix
+	DHTProtocol = DHTProtocolPrefix + "/kad/1.0.0"
+	// GlobalNamespace is advertised by every Blue Otter node. Peers found under it only keep the DHT healthy,
+	// room members are found under the namespace of each room instead.
+	GlobalNamespace = "--blue-otter-namespace--"
+	// GlobalPeerLimit bounds how many connections a node makes to peers found under GlobalNamespace
+	GlobalPeerLimit = 8
+)
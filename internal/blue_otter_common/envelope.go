@@ -0,0 +1,178 @@
+package common
+
+// envelope.go contains the wire format helpers and the payload decoder registry for room messages
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// ProtocolVersion is the envelope version produced by this client
+const ProtocolVersion = 1
+
+// Message kinds understood by this client
+const (
+	KindChat         = "chat"
+	KindNotification = "notification"
+	KindSealed       = "sealed"
+	KindDirect       = "dm"
+	KindPresence     = "presence"
+	KindModeration   = "moderation"
+)
+
+var (
+	// ErrMalformedEnvelope is returned when data is not a valid envelope
+	ErrMalformedEnvelope = errors.New("malformed envelope")
+	// ErrUnknownKind is returned when no decoder is registered for an envelope kind
+	ErrUnknownKind = errors.New("unknown message kind")
+	// ErrMissingSignature is returned when verifying an envelope that was never signed
+	ErrMissingSignature = errors.New("envelope is not signed")
+	// ErrInvalidSignature is returned when an envelope signature does not match the claimed key
+	ErrInvalidSignature = errors.New("invalid envelope signature")
+)
+
+// PayloadDecoder turns the raw payload of an envelope into a typed value
+type PayloadDecoder func(raw json.RawMessage) (any, error)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]PayloadDecoder{}
+)
+
+func init() {
+	RegisterKind(KindChat, decodeAs[ChatMessage])
+	RegisterKind(KindNotification, decodeAs[SystemNotification])
+	RegisterKind(KindDirect, decodeAs[DirectMessage])
+	RegisterKind(KindPresence, decodeAs[Presence])
+	RegisterKind(KindModeration, decodeAs[Moderation])
+}
+
+// RegisterKind registers the decoder used for envelopes of the given kind, replacing any existing one
+func RegisterKind(kind string, decoder PayloadDecoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[kind] = decoder
+}
+
+func decodeAs[T any](raw json.RawMessage) (any, error) {
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// NewMessageID returns a random identifier for an envelope
+func NewMessageID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand never fails on supported platforms, fall back to the clock just in case
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// NewEnvelope wraps a payload of the given kind in a new envelope from sender
+func NewEnvelope(kind string, sender string, payload any) (Envelope, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to encode %s payload: %w", kind, err)
+	}
+
+	return Envelope{
+		Version:   ProtocolVersion,
+		Kind:      kind,
+		ID:        NewMessageID(),
+		Sender:    sender,
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   raw,
+	}, nil
+}
+
+// EncodeEnvelope serializes an envelope for publishing
+func EncodeEnvelope(env Envelope) ([]byte, error) {
+	return json.Marshal(env)
+}
+
+// DecodeEnvelope parses data received from a topic into an envelope
+func DecodeEnvelope(data []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+
+	if env.Version < 1 || env.Kind == "" || env.ID == "" {
+		return Envelope{}, ErrMalformedEnvelope
+	}
+
+	return env, nil
+}
+
+// DecodePayload decodes the payload of an envelope with the decoder registered for its kind
+func (env Envelope) DecodePayload() (any, error) {
+	decodersMu.RLock()
+	decoder, ok := decoders[env.Kind]
+	decodersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKind, env.Kind)
+	}
+
+	payload, err := decoder(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s payload: %w", env.Kind, err)
+	}
+
+	return payload, nil
+}
+
+// signingBytes returns the canonical bytes covered by the envelope signature
+func (env Envelope) signingBytes() ([]byte, error) {
+	env.Signature = nil
+	return json.Marshal(env)
+}
+
+// Sign signs the envelope with the given private key
+func (env *Envelope) Sign(key crypto.PrivKey) error {
+	data, err := env.signingBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode envelope for signing: %w", err)
+	}
+
+	sig, err := key.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign envelope: %w", err)
+	}
+
+	env.Signature = sig
+	return nil
+}
+
+// Verify checks the envelope signature against the given public key
+func (env Envelope) Verify(pub crypto.PubKey) error {
+	if len(env.Signature) == 0 {
+		return ErrMissingSignature
+	}
+
+	data, err := env.signingBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode envelope for verification: %w", err)
+	}
+
+	ok, err := pub.Verify(data, env.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{name: "not json", data: "hello", want: ErrMalformedEnvelope},
+		{name: "empty object", data: "{}", want: ErrMalformedEnvelope},
+		{name: "no version", data: `{"kind":"chat","id":"1"}`, want: ErrMalformedEnvelope},
+		{name: "no kind", data: `{"version":1,"id":"1"}`, want: ErrMalformedEnvelope},
+		{name: "no id", data: `{"version":1,"kind":"chat"}`, want: ErrMalformedEnvelope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeEnvelope([]byte(tt.data)); !errors.Is(err, tt.want) {
+				t.Errorf("DecodeEnvelope() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvelopePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		payload any
+		want    any
+		wantErr error
+	}{
+		{name: "chat", kind: KindChat, payload: ChatMessage{Sender: "Alice", Text: "hello"}, want: ChatMessage{Sender: "Alice", Text: "hello"}},
+		{
+			name:    "notification",
+			kind:    KindNotification,
+			payload: SystemNotification{Type: "join", Message: "Alice joined"},
+			want:    SystemNotification{Type: "join", Message: "Alice joined"},
+		},
+		{name: "unknown kind", kind: "shout", payload: "hello", wantErr: ErrUnknownKind},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := NewEnvelope(tt.kind, "sender", tt.payload)
+			if err != nil {
+				t.Fatalf("NewEnvelope: %v", err)
+			}
+
+			data, err := EncodeEnvelope(env)
+			if err != nil {
+				t.Fatalf("EncodeEnvelope: %v", err)
+			}
+			decoded, err := DecodeEnvelope(data)
+			if err != nil {
+				t.Fatalf("DecodeEnvelope: %v", err)
+			}
+			if decoded.ID != env.ID || decoded.Kind != tt.kind || decoded.Version != ProtocolVersion {
+				t.Errorf("DecodeEnvelope() = %+v, want %+v", decoded, env)
+			}
+
+			payload, err := decoded.DecodePayload()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecodePayload() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && payload != tt.want {
+				t.Errorf("DecodePayload() = %#v, want %#v", payload, tt.want)
+			}
+		})
+	}
+}
+
+func newTestKey(t *testing.T) crypto.PrivKey {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+func TestEnvelopeSignVerify(t *testing.T) {
+	signer := newTestKey(t)
+	other := newTestKey(t)
+
+	tests := []struct {
+		name   string
+		sign   bool
+		tamper func(env *Envelope)
+		verify crypto.PubKey
+		want   error
+	}{
+		{name: "signed", sign: true, verify: signer.GetPublic()},
+		{name: "unsigned", sign: false, verify: signer.GetPublic(), want: ErrMissingSignature},
+		{name: "wrong key", sign: true, verify: other.GetPublic(), want: ErrInvalidSignature},
+		{
+			name:   "payload changed",
+			sign:   true,
+			tamper: func(env *Envelope) { env.Payload = []byte(`{"text":"changed"}`) },
+			verify: signer.GetPublic(),
+			want:   ErrInvalidSignature,
+		},
+		{
+			name:   "timestamp changed",
+			sign:   true,
+			tamper: func(env *Envelope) { env.Timestamp-- },
+			verify: signer.GetPublic(),
+			want:   ErrInvalidSignature,
+		},
+		{
+			name:   "sender changed",
+			sign:   true,
+			tamper: func(env *Envelope) { env.Sender = "someone-else" },
+			verify: signer.GetPublic(),
+			want:   ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := NewEnvelope(KindChat, "sender", ChatMessage{Sender: "sender", Text: "hello"})
+			if err != nil {
+				t.Fatalf("NewEnvelope: %v", err)
+			}
+			if tt.sign {
+				if err := env.Sign(signer); err != nil {
+					t.Fatalf("Sign: %v", err)
+				}
+			}
+			if tt.tamper != nil {
+				tt.tamper(&env)
+			}
+
+			// Signatures must survive the trip over the wire
+			data, err := EncodeEnvelope(env)
+			if err != nil {
+				t.Fatalf("EncodeEnvelope: %v", err)
+			}
+			decoded, err := DecodeEnvelope(data)
+			if err != nil {
+				t.Fatalf("DecodeEnvelope: %v", err)
+			}
+
+			if err := decoded.Verify(tt.verify); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package common
+
+// invite.go contains the signed invitation tokens used to join invite-only rooms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	// ErrInvalidInvitation is returned when an invitation token is malformed or its signature does not match the inviter
+	ErrInvalidInvitation = errors.New("invalid invitation")
+	// ErrInvitationExpired is returned when an invitation is redeemed after its expiry
+	ErrInvitationExpired = errors.New("invitation has expired")
+	// ErrNotRoomOwner is returned when an invitation is not signed by the owner of the room it is for
+	ErrNotRoomOwner = errors.New("only the owner of a room can invite to it")
+)
+
+// RoomOwnerSeparator separates the name of an owned room from the peer ID of its owner
+const RoomOwnerSeparator = "@"
+
+// RoomOwner returns the owner carried in the name of an owned room, or an empty ID for rooms without an owner
+func RoomOwner(roomName string) peer.ID {
+	i := strings.LastIndex(roomName, RoomOwnerSeparator)
+	if i < 0 {
+		return ""
+	}
+	owner, err := peer.Decode(roomName[i+len(RoomOwnerSeparator):])
+	if err != nil {
+		return ""
+	}
+	return owner
+}
+
+// NewInvitation creates an invitation to roomName carrying its room key, valid for ttl and signed with the inviter's
+// key. Only the owner named in roomName can invite to it.
+func NewInvitation(roomName string, key []byte, ttl time.Duration, inviterKey crypto.PrivKey) (Invitation, error) {
+	inviter, err := peer.IDFromPrivateKey(inviterKey)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("failed to derive inviter identity: %w", err)
+	}
+	if RoomOwner(roomName) != inviter {
+		return Invitation{}, fmt.Errorf("%w: %s is not owned by %s", ErrNotRoomOwner, roomName, inviter)
+	}
+
+	inv := Invitation{
+		Room:    roomName,
+		Key:     key,
+		Expires: time.Now().Add(ttl).UnixMilli(),
+		Inviter: inviter.String(),
+	}
+
+	data, err := inv.signingBytes()
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	sig, err := inviterKey.Sign(data)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("failed to sign invitation: %w", err)
+	}
+
+	inv.Signature = sig
+	return inv, nil
+}
+
+// signingBytes returns the canonical bytes covered by the invitation signature
+func (inv Invitation) signingBytes() ([]byte, error) {
+	inv.Signature = nil
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode invitation: %w", err)
+	}
+	return data, nil
+}
+
+// ExpiresAt returns when the invitation stops being accepted
+func (inv Invitation) ExpiresAt() time.Time {
+	return time.UnixMilli(inv.Expires)
+}
+
+// Encode returns the invitation as a single token that is safe to paste into a shell or a chat
+func (inv Invitation) Encode() (string, error) {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode invitation: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeInvitation parses an invitation token and checks that it is signed by the owner of the room and has not
+// expired. The expiry only limits when a token is accepted: the room key can still be read out of an expired token.
+func DecodeInvitation(token string) (Invitation, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(token))
+	if err != nil {
+		return Invitation{}, fmt.Errorf("%w: %v", ErrInvalidInvitation, err)
+	}
+
+	var inv Invitation
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return Invitation{}, fmt.Errorf("%w: %v", ErrInvalidInvitation, err)
+	}
+
+	if inv.Room == "" || len(inv.Key) != RoomKeySize || len(inv.Signature) == 0 {
+		return Invitation{}, ErrInvalidInvitation
+	}
+
+	inviter, err := peer.Decode(inv.Inviter)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("%w: bad inviter: %v", ErrInvalidInvitation, err)
+	}
+
+	pub, err := inviter.ExtractPublicKey()
+	if err != nil {
+		return Invitation{}, fmt.Errorf("%w: bad inviter: %v", ErrInvalidInvitation, err)
+	}
+
+	signed, err := inv.signingBytes()
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	if ok, err := pub.Verify(signed, inv.Signature); err != nil || !ok {
+		return Invitation{}, fmt.Errorf("%w: signature does not match inviter", ErrInvalidInvitation)
+	}
+
+	// Anyone can sign a token, only the owner's hands out the real key of the room
+	if RoomOwner(inv.Room) != inviter {
+		return Invitation{}, fmt.Errorf("%w: %w: %s is not owned by %s", ErrInvalidInvitation, ErrNotRoomOwner, inv.Room, inviter)
+	}
+
+	if time.Now().After(inv.ExpiresAt()) {
+		return Invitation{}, fmt.Errorf("%w on %s", ErrInvitationExpired, inv.ExpiresAt().Format("Jan 2 15:04"))
+	}
+
+	return inv, nil
+}
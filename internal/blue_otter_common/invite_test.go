@@ -0,0 +1,161 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ownedTestRoom returns the name of a room owned by the holder of key
+func ownedTestRoom(t *testing.T, name string, key crypto.PrivKey) string {
+	t.Helper()
+	owner, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to derive peer ID: %v", err)
+	}
+	return name + RoomOwnerSeparator + owner.String()
+}
+
+func TestDecodeInvitation(t *testing.T) {
+	inviter := newTestKey(t)
+	otherOwner := newTestKey(t)
+	room := ownedTestRoom(t, "room", inviter)
+	key, err := GenerateRoomKey()
+	if err != nil {
+		t.Fatalf("GenerateRoomKey: %v", err)
+	}
+
+	// encode re-encodes an invitation after a test has changed it, keeping the original signature
+	encode := func(inv Invitation) string {
+		data, err := json.Marshal(inv)
+		if err != nil {
+			t.Fatalf("failed to encode invitation: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	// resign signs an invitation again after a test has changed it, as a forger holding the inviter key would
+	resign := func(inv *Invitation) {
+		data, err := inv.signingBytes()
+		if err != nil {
+			t.Fatalf("signingBytes: %v", err)
+		}
+		if inv.Signature, err = inviter.Sign(data); err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		tamper func(inv *Invitation)
+		token  string
+		want   error
+	}{
+		{name: "valid", ttl: time.Hour},
+		{name: "expired", ttl: -time.Minute, want: ErrInvitationExpired},
+		{
+			name: "unowned room",
+			ttl:  time.Hour,
+			tamper: func(inv *Invitation) {
+				inv.Room = "room"
+				resign(inv)
+			},
+			want: ErrNotRoomOwner,
+		},
+		{
+			name: "wrong inviter",
+			ttl:  time.Hour,
+			tamper: func(inv *Invitation) {
+				inv.Room = ownedTestRoom(t, "room", otherOwner)
+				resign(inv)
+			},
+			want: ErrNotRoomOwner,
+		},
+		{name: "room changed", ttl: time.Hour, tamper: func(inv *Invitation) { inv.Room = ownedTestRoom(t, "other-room", inviter) }, want: ErrInvalidInvitation},
+		{name: "expiry extended", ttl: -time.Minute, tamper: func(inv *Invitation) { inv.Expires += time.Hour.Milliseconds() }, want: ErrInvalidInvitation},
+		{name: "key changed", ttl: time.Hour, tamper: func(inv *Invitation) { inv.Key = make([]byte, RoomKeySize) }, want: ErrInvalidInvitation},
+		{name: "key too short", ttl: time.Hour, tamper: func(inv *Invitation) { inv.Key = inv.Key[:8] }, want: ErrInvalidInvitation},
+		{name: "inviter changed", ttl: time.Hour, tamper: func(inv *Invitation) { inv.Inviter = "not-a-peer" }, want: ErrInvalidInvitation},
+		{name: "unsigned", ttl: time.Hour, tamper: func(inv *Invitation) { inv.Signature = nil }, want: ErrInvalidInvitation},
+		{name: "not base64", token: "not a token!", want: ErrInvalidInvitation},
+		{name: "not json", token: base64.RawURLEncoding.EncodeToString([]byte("hello")), want: ErrInvalidInvitation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.token
+			if token == "" {
+				inv, err := NewInvitation(room, key, tt.ttl, inviter)
+				if err != nil {
+					t.Fatalf("NewInvitation: %v", err)
+				}
+				if tt.tamper != nil {
+					tt.tamper(&inv)
+				}
+				token = encode(inv)
+			}
+
+			inv, err := DecodeInvitation(token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DecodeInvitation() = %v, want %v", err, tt.want)
+			}
+			if err == nil && (inv.Room != room || string(inv.Key) != string(key)) {
+				t.Errorf("DecodeInvitation() = %+v, want room %q with the generated key", inv, room)
+			}
+		})
+	}
+}
+
+func TestNewInvitation(t *testing.T) {
+	inviter := newTestKey(t)
+	otherOwner := newTestKey(t)
+	key, err := GenerateRoomKey()
+	if err != nil {
+		t.Fatalf("GenerateRoomKey: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		roomName string
+		want     error
+	}{
+		{name: "owned room", roomName: ownedTestRoom(t, "room", inviter)},
+		{name: "unowned room", roomName: "room", want: ErrNotRoomOwner},
+		{name: "room owned by someone else", roomName: ownedTestRoom(t, "room", otherOwner), want: ErrNotRoomOwner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewInvitation(tt.roomName, key, time.Hour, inviter); !errors.Is(err, tt.want) {
+				t.Errorf("NewInvitation() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRoomKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{name: "generated size", size: RoomKeySize},
+		{name: "too short", size: RoomKeySize / 2, wantErr: true},
+		{name: "too long", size: RoomKeySize * 2, wantErr: true},
+		{name: "empty", size: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRoomKey("room", make([]byte, tt.size)); (err != nil) != tt.wantErr {
+				t.Errorf("NewRoomKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package common
+
+// listen.go contains the listen addresses for every transport the application supports
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+// DefaultListenAddrs returns listen addresses on port for TCP, WebSocket, QUIC-v1 and WebTransport,
+// over both IPv4 and IPv6. TCP and WebSocket share the TCP port, QUIC and WebTransport share the UDP port.
+func DefaultListenAddrs(port string) []string {
+	var addrs []string
+	for _, ip := range []string{"/ip4/0.0.0.0", "/ip6/::"} {
+		addrs = append(addrs,
+			ip+"/tcp/"+port,
+			ip+"/tcp/"+port+"/ws",
+			ip+"/udp/"+port+"/quic-v1",
+			ip+"/udp/"+port+"/quic-v1/webtransport",
+		)
+	}
+	return addrs
+}
+
+// ValidateListenAddrs checks that every listen address is a valid multiaddr
+func ValidateListenAddrs(addrs []string) error {
+	if len(addrs) == 0 {
+		return fmt.Errorf("no listen addresses given")
+	}
+	for _, addr := range addrs {
+		if _, err := multiaddr.NewMultiaddr(addr); err != nil {
+			return fmt.Errorf("invalid listen address %q: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+// PrivateNetworkListenAddrs splits listen addresses into those usable in a private network and those that are not.
+// Pre-shared keys only work over TCP and WebSocket, and WebSocket can no longer share a port with plain TCP.
+func PrivateNetworkListenAddrs(addrs []string) (usable []string, dropped []string) {
+	tcpAddrs := make(map[string]bool)
+	for _, addr := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			continue
+		}
+		if isPlainTCP(maddr) {
+			tcpAddrs[maddr.String()] = true
+		}
+	}
+
+	for _, addr := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			dropped = append(dropped, addr)
+			continue
+		}
+
+		if _, err := maddr.ValueForProtocol(multiaddr.P_UDP); err == nil {
+			dropped = append(dropped, addr)
+			continue
+		}
+
+		if _, err := maddr.ValueForProtocol(multiaddr.P_WS); err == nil {
+			base, _ := multiaddr.SplitLast(maddr)
+			if tcpAddrs[base.String()] && !strings.HasSuffix(base.String(), "/tcp/0") {
+				dropped = append(dropped, addr)
+				continue
+			}
+		}
+
+		usable = append(usable, addr)
+	}
+
+	return usable, dropped
+}
+
+func isPlainTCP(maddr multiaddr.Multiaddr) bool {
+	protocols := maddr.Protocols()
+	return len(protocols) == 2 && protocols[1].Code == multiaddr.P_TCP
+}
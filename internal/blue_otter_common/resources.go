@@ -0,0 +1,193 @@
+package common
+
+// resources.go contains the connection manager and resource manager limits shared by client and bootstrap nodes
+
+import (
+	"fmt"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/pbnjay/memory"
+)
+
+// BootstrapPeerTag protects connections to bootstrap nodes from being trimmed by the connection manager
+const BootstrapPeerTag = "blue-otter-bootstrap"
+
+// ResourceLimits bounds the connections, memory and file descriptors a node uses
+type ResourceLimits struct {
+	// LowWater is how many connections the connection manager trims down to
+	LowWater int
+	// HighWater is how many connections start a trim
+	HighWater int
+	// GracePeriod is how long a new connection is safe from trimming
+	GracePeriod time.Duration
+	// MaxMemory is the memory the resource limits are scaled to, in MiB. Zero uses an eighth of the system memory.
+	MaxMemory int64
+	// MaxFDs is how many file descriptors connections may use. Zero uses half of the process limit.
+	MaxFDs int
+}
+
+// DefaultClientResourceLimits returns limits for a client, which needs its DHT peers and the members of its rooms
+func DefaultClientResourceLimits() ResourceLimits {
+	return ResourceLimits{
+		LowWater:    32,
+		HighWater:   96,
+		GracePeriod: time.Minute,
+	}
+}
+
+// DefaultBootstrapResourceLimits returns limits for a bootstrap node, which every client connects to. The high
+// watermark fits the resource limits of the smallest machines, larger ones can raise it.
+func DefaultBootstrapResourceLimits() ResourceLimits {
+	return ResourceLimits{
+		LowWater:    64,
+		HighWater:   128,
+		GracePeriod: 30 * time.Second,
+	}
+}
+
+// Validate checks that the watermarks are positive and ordered and that no limit is negative
+func (l ResourceLimits) Validate() error {
+	if l.LowWater <= 0 || l.HighWater <= 0 {
+		return fmt.Errorf("connection watermarks must be positive")
+	}
+	if l.LowWater >= l.HighWater {
+		return fmt.Errorf("low connection watermark must be below the high watermark")
+	}
+	if l.GracePeriod < 0 || l.MaxMemory < 0 || l.MaxFDs < 0 {
+		return fmt.Errorf("resource limits cannot be negative")
+	}
+	return nil
+}
+
+// HostResources is the connection manager and resource manager of a host, kept so their usage can be reported
+type HostResources struct {
+	limits  ResourceLimits
+	connMgr *connmgr.BasicConnMgr
+	limiter rcmgr.Limiter
+	manager network.ResourceManager
+}
+
+// NewHostResources creates the connection manager and resource manager for limits. The resource manager starts from
+// the libp2p defaults scaled to MaxMemory and MaxFDs.
+func NewHostResources(limits ResourceLimits) (*HostResources, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+
+	connMgr, err := connmgr.NewConnManager(limits.LowWater, limits.HighWater, connmgr.WithGracePeriod(limits.GracePeriod))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection manager: %w", err)
+	}
+
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+
+	mem := limits.MaxMemory << 20
+	if mem == 0 {
+		mem = int64(memory.TotalMemory()) / 8
+	}
+	fds := limits.MaxFDs
+	if fds == 0 {
+		fds = int(scaling.AutoScale().ToPartialLimitConfig().System.FD)
+	}
+
+	limiter := rcmgr.NewFixedLimiter(scaling.Scale(mem, fds))
+	manager, err := rcmgr.NewResourceManager(limiter)
+	if err != nil {
+		connMgr.Close()
+		return nil, fmt.Errorf("failed to create resource manager: %w", err)
+	}
+
+	return &HostResources{limits: limits, connMgr: connMgr, limiter: limiter, manager: manager}, nil
+}
+
+// Options returns the libp2p options installing the connection manager and resource manager
+func (r *HostResources) Options() []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.ConnectionManager(r.connMgr),
+		libp2p.ResourceManager(r.manager),
+	}
+}
+
+// Close stops the connection manager and resource manager of a host that failed to start. The host closes them
+// itself otherwise.
+func (r *HostResources) Close() {
+	r.manager.Close()
+	r.connMgr.Close()
+}
+
+// CheckLimits reports when the resource limits refuse connections before the high watermark is reached, in which
+// case the connection manager never gets to trim
+func (r *HostResources) CheckLimits() error {
+	if conns := r.limiter.GetSystemLimits().GetConnTotalLimit(); r.limits.HighWater > conns {
+		return fmt.Errorf("high connection watermark %d is above the %d connections the resource limits allow", r.limits.HighWater, conns)
+	}
+	return nil
+}
+
+// ResourceUsage is a snapshot of the connections, streams, memory and file descriptors a host uses
+type ResourceUsage struct {
+	Peers           int   `json:"peers"`
+	Protected       int   `json:"protected"`
+	LowWater        int   `json:"low_water"`
+	HighWater       int   `json:"high_water"`
+	ConnsInbound    int   `json:"conns_inbound"`
+	ConnsOutbound   int   `json:"conns_outbound"`
+	ConnLimit       int   `json:"conn_limit"`
+	StreamsInbound  int   `json:"streams_inbound"`
+	StreamsOutbound int   `json:"streams_outbound"`
+	StreamLimit     int   `json:"stream_limit"`
+	Memory          int64 `json:"memory"`
+	MemoryLimit     int64 `json:"memory_limit"`
+	FDs             int   `json:"fds"`
+	FDLimit         int   `json:"fd_limit"`
+}
+
+// Usage reports what h currently uses against its limits
+func (r *HostResources) Usage(h host.Host) ResourceUsage {
+	system := r.limiter.GetSystemLimits()
+	usage := ResourceUsage{
+		LowWater:    r.limits.LowWater,
+		HighWater:   r.limits.HighWater,
+		ConnLimit:   system.GetConnTotalLimit(),
+		StreamLimit: system.GetStreamTotalLimit(),
+		MemoryLimit: system.GetMemoryLimit(),
+		FDLimit:     system.GetFDLimit(),
+	}
+
+	for _, p := range h.Network().Peers() {
+		usage.Peers++
+		if r.connMgr.IsProtected(p, "") {
+			usage.Protected++
+		}
+	}
+
+	r.manager.ViewSystem(func(scope network.ResourceScope) error {
+		stat := scope.Stat()
+		usage.ConnsInbound = stat.NumConnsInbound
+		usage.ConnsOutbound = stat.NumConnsOutbound
+		usage.StreamsInbound = stat.NumStreamsInbound
+		usage.StreamsOutbound = stat.NumStreamsOutbound
+		usage.Memory = stat.Memory
+		usage.FDs = stat.NumFD
+		return nil
+	})
+
+	return usage
+}
+
+// Lines describes the usage for printing, one resource per line
+func (u ResourceUsage) Lines() []string {
+	return []string{
+		fmt.Sprintf("Peers: %d connected, %d protected from trimming (trimmed to %d above %d)", u.Peers, u.Protected, u.LowWater, u.HighWater),
+		fmt.Sprintf("Connections: %d inbound, %d outbound of %d", u.ConnsInbound, u.ConnsOutbound, u.ConnLimit),
+		fmt.Sprintf("Streams: %d inbound, %d outbound of %d", u.StreamsInbound, u.StreamsOutbound, u.StreamLimit),
+		fmt.Sprintf("Memory: %.1f MiB of %.1f MiB", float64(u.Memory)/(1<<20), float64(u.MemoryLimit)/(1<<20)),
+		fmt.Sprintf("File descriptors: %d of %d", u.FDs, u.FDLimit),
+	}
+}
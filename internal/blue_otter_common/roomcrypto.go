@@ -0,0 +1,131 @@
+package common
+
+// roomcrypto.go contains the symmetric encryption used for rooms protected by a shared secret or an invitation
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// roomKeyIterations is the PBKDF2 work factor used when deriving a room key from a passphrase
+	roomKeyIterations = 600000
+	// RoomKeySize is the length of a room key in bytes
+	RoomKeySize = 32
+)
+
+// ErrUndecryptable is returned when a sealed envelope cannot be opened with the room key
+var ErrUndecryptable = errors.New("message could not be decrypted with the room key")
+
+// RoomKey encrypts and decrypts envelopes for a single room
+type RoomKey struct {
+	roomName string
+	aead     cipher.AEAD
+}
+
+// DeriveRoomKey derives the symmetric key for a room from a shared passphrase
+func DeriveRoomKey(roomName string, secret string) (*RoomKey, error) {
+	key, err := pbkdf2.Key(sha256.New, secret, []byte("blue-otter-room:"+roomName), roomKeyIterations, RoomKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive room key: %w", err)
+	}
+
+	return newRoomKey(roomName, key)
+}
+
+// GenerateRoomKey returns random key material for a room that is not protected by a passphrase
+func GenerateRoomKey() ([]byte, error) {
+	key := make([]byte, RoomKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate room key: %w", err)
+	}
+	return key, nil
+}
+
+// NewRoomKey creates the key for a room from key material made by GenerateRoomKey
+func NewRoomKey(roomName string, key []byte) (*RoomKey, error) {
+	if len(key) != RoomKeySize {
+		return nil, fmt.Errorf("room key must be %d bytes, got %d", RoomKeySize, len(key))
+	}
+	return newRoomKey(roomName, key)
+}
+
+func newRoomKey(roomName string, key []byte) (*RoomKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create room cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create room cipher: %w", err)
+	}
+
+	return &RoomKey{roomName: roomName, aead: aead}, nil
+}
+
+// Seal encrypts env into a sealed envelope that keeps only the routing metadata in the clear
+func (k *RoomKey) Seal(env Envelope) (Envelope, error) {
+	plaintext, err := EncodeEnvelope(env)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to encode envelope for sealing: %w", err)
+	}
+
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Envelope{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := SealedPayload{
+		Nonce:      nonce,
+		Ciphertext: k.aead.Seal(nil, nonce, plaintext, []byte(k.roomName)),
+	}
+
+	raw, err := json.Marshal(sealed)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to encode sealed payload: %w", err)
+	}
+
+	return Envelope{
+		Version:   ProtocolVersion,
+		Kind:      KindSealed,
+		ID:        env.ID,
+		Sender:    env.Sender,
+		Timestamp: env.Timestamp,
+		Payload:   raw,
+	}, nil
+}
+
+// Open decrypts a sealed envelope and returns the envelope it carries
+func (k *RoomKey) Open(outer Envelope) (Envelope, error) {
+	var sealed SealedPayload
+	if err := json.Unmarshal(outer.Payload, &sealed); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+
+	if len(sealed.Nonce) != k.aead.NonceSize() {
+		return Envelope{}, ErrUndecryptable
+	}
+
+	plaintext, err := k.aead.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(k.roomName))
+	if err != nil {
+		return Envelope{}, ErrUndecryptable
+	}
+
+	inner, err := DecodeEnvelope(plaintext)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	if inner.ID != outer.ID || inner.Sender != outer.Sender {
+		return Envelope{}, fmt.Errorf("%w: sealed metadata does not match contents", ErrMalformedEnvelope)
+	}
+
+	return inner, nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestRoomKey(t *testing.T, roomName string, secret string) *RoomKey {
+	t.Helper()
+	key, err := DeriveRoomKey(roomName, secret)
+	if err != nil {
+		t.Fatalf("DeriveRoomKey: %v", err)
+	}
+	return key
+}
+
+func TestRoomKeySealOpen(t *testing.T) {
+	sealer := newTestRoomKey(t, "room", "secret")
+
+	tests := []struct {
+		name   string
+		opener *RoomKey
+		tamper func(outer *Envelope)
+		want   error
+	}{
+		{name: "same secret", opener: newTestRoomKey(t, "room", "secret")},
+		{name: "other secret", opener: newTestRoomKey(t, "room", "other secret"), want: ErrUndecryptable},
+		// The room name salts the key and is authenticated, so a message cannot be replayed into another room
+		{name: "other room", opener: newTestRoomKey(t, "other-room", "secret"), want: ErrUndecryptable},
+		{
+			name:   "ciphertext changed",
+			opener: sealer,
+			tamper: func(outer *Envelope) {
+				outer.Payload = []byte(`{"nonce":"AAAAAAAAAAAAAAAA","ciphertext":"AAAA"}`)
+			},
+			want: ErrUndecryptable,
+		},
+		{
+			name:   "bad nonce",
+			opener: sealer,
+			tamper: func(outer *Envelope) { outer.Payload = []byte(`{"nonce":"AA==","ciphertext":"AAAA"}`) },
+			want:   ErrUndecryptable,
+		},
+		{
+			name:   "outer sender changed",
+			opener: sealer,
+			tamper: func(outer *Envelope) { outer.Sender = "someone-else" },
+			want:   ErrMalformedEnvelope,
+		},
+		{
+			name:   "outer id changed",
+			opener: sealer,
+			tamper: func(outer *Envelope) { outer.ID = NewMessageID() },
+			want:   ErrMalformedEnvelope,
+		},
+		{
+			name:   "payload not sealed",
+			opener: sealer,
+			tamper: func(outer *Envelope) { outer.Payload = []byte(`"plain"`) },
+			want:   ErrMalformedEnvelope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := NewEnvelope(KindChat, "sender", ChatMessage{Sender: "sender", Text: "secret"})
+			if err != nil {
+				t.Fatalf("NewEnvelope: %v", err)
+			}
+
+			outer, err := sealer.Seal(env)
+			if err != nil {
+				t.Fatalf("Seal: %v", err)
+			}
+			if outer.Kind != KindSealed || outer.ID != env.ID || outer.Sender != env.Sender {
+				t.Fatalf("sealed envelope does not keep the routing metadata: %+v", outer)
+			}
+			if tt.tamper != nil {
+				tt.tamper(&outer)
+			}
+
+			inner, err := tt.opener.Open(outer)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Open() = %v, want %v", err, tt.want)
+			}
+			if err == nil && string(inner.Payload) != string(env.Payload) {
+				t.Errorf("Open() payload = %s, want %s", inner.Payload, env.Payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package common
+
+// startup.go contains the errors a node can fail to start with, which the CLI turns into distinct exit codes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrPortInUse is returned when another process already listens on one of the listen addresses
+	ErrPortInUse = errors.New("listen address already in use")
+	// ErrKeyCorrupt is returned when the saved identity or swarm key cannot be decoded
+	ErrKeyCorrupt = errors.New("saved key is corrupt")
+	// ErrDHTFailed is returned when the DHT cannot be started
+	ErrDHTFailed = errors.New("failed to start the DHT")
+)
+
+// ListenError classifies an error creating a host, so a taken listen address is reported as ErrPortInUse. libp2p
+// only keeps the text of listen errors, so the text is checked too.
+func ListenError(err error) error {
+	if errors.Is(err, syscall.EADDRINUSE) || strings.Contains(err.Error(), "address already in use") {
+		return fmt.Errorf("%w: %v", ErrPortInUse, err)
+	}
+	return err
+}
@@ -0,0 +1,547 @@
+package blue_otter_daemon
+
+// daemon.go contains all functions related to the headless client daemon and its JSON-RPC control socket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	client "github.com/patrickma6199/blue-otter/internal/blue_otter_client"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+const (
+	// maxRequestSize bounds a single request line read from the control socket
+	maxRequestSize = 1024 * 1024
+	// connectionQueueSize is how many responses and notifications may wait to be written to a control socket client
+	connectionQueueSize = 256
+	// writeTimeout bounds writing a single response or notification to a control socket client
+	writeTimeout = 10 * time.Second
+)
+
+// Request is a JSON-RPC 2.0 request read from the control socket
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response written to the control socket
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// Notification is a JSON-RPC 2.0 notification pushed to subscribed connections
+type Notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// RPCError is the error object of a failed JSON-RPC call
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// SendParams are the parameters of the "send" method
+type SendParams struct {
+	Room string `json:"room,omitempty"`
+	To   string `json:"to,omitempty"`
+	Text string `json:"text"`
+}
+
+// SubscribeParams are the parameters of the "subscribe" method
+type SubscribeParams struct {
+	Rooms  []string `json:"rooms,omitempty"`
+	Direct bool     `json:"direct,omitempty"`
+}
+
+// ListPeersParams are the parameters of the "list_peers" method
+type ListPeersParams struct {
+	Room string `json:"room,omitempty"`
+}
+
+// JoinRoomParams are the parameters of the "join_room" and "part_room" methods. Create joins a room owned by the
+// daemon, named after Room and the daemon's peer ID.
+type JoinRoomParams struct {
+	Room   string `json:"room"`
+	Secret string `json:"secret,omitempty"`
+	Create bool   `json:"create,omitempty"`
+}
+
+// ModerateParams are the parameters of the "moderate" method. Duration is a Go duration such as "30m", empty for
+// a permanent ban or mute.
+type ModerateParams struct {
+	Room     string `json:"room"`
+	Action   string `json:"action"`
+	Target   string `json:"target"`
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// EventHub is the event sink of a daemon session. It logs every event and fans messages out to subscribers.
+type EventHub struct {
+	log *client.WriterSink
+
+	mu          sync.Mutex
+	subscribers map[int]func(client.MessageEvent)
+	nextID      int
+}
+
+// NewEventHub creates a hub logging events to out
+func NewEventHub(out io.Writer) *EventHub {
+	return &EventHub{
+		log:         client.NewWriterSink(out),
+		subscribers: make(map[int]func(client.MessageEvent)),
+	}
+}
+
+// HandleEvent logs ev and delivers messages to every subscriber
+func (h *EventHub) HandleEvent(ev client.Event) {
+	h.log.HandleEvent(ev)
+
+	var messages []client.MessageEvent
+	switch e := ev.(type) {
+	case client.MessageEvent:
+		messages = []client.MessageEvent{e}
+	case client.HistoryEvent:
+		if e.Synced {
+			for _, msg := range e.Messages {
+				messages = append(messages, msg)
+			}
+		}
+	default:
+		return
+	}
+
+	h.mu.Lock()
+	subscribers := make([]func(client.MessageEvent), 0, len(h.subscribers))
+	for _, subscriber := range h.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	h.mu.Unlock()
+
+	for _, msg := range messages {
+		for _, subscriber := range subscribers {
+			subscriber(msg)
+		}
+	}
+}
+
+// subscribe registers a message subscriber and returns a function that removes it
+func (h *EventHub) subscribe(subscriber func(client.MessageEvent)) (remove func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := h.nextID
+	h.nextID++
+	h.subscribers[id] = subscriber
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers, id)
+	}
+}
+
+// Server serves the control API of a session on a Unix domain socket
+type Server struct {
+	ctx      context.Context
+	session  *client.Session
+	hub      *EventHub
+	listener net.Listener
+	path     string
+}
+
+// Serve starts serving the control API for session on socketPath until ctx is cancelled or Close is called
+func Serve(ctx context.Context, session *client.Session, hub *EventHub, socketPath string) (*Server, error) {
+	// A socket left behind by a daemon that did not shut down cleanly would make Listen fail
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another daemon is already listening on %s", socketPath)
+	}
+	os.Remove(socketPath)
+
+	// Anyone who can open the socket can speak as us, so keep it private to the current user
+	listener, err := listenPrivate(socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+
+	srv := &Server{
+		ctx:      ctx,
+		session:  session,
+		hub:      hub,
+		listener: listener,
+		path:     socketPath,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	go srv.acceptLoop()
+
+	return srv, nil
+}
+
+// Close stops accepting connections and removes the socket file
+func (srv *Server) Close() error {
+	err := srv.listener.Close()
+	os.Remove(srv.path)
+	return err
+}
+
+func (srv *Server) acceptLoop() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		go srv.handleConn(conn)
+	}
+}
+
+// connection is a single control socket client. Responses and notifications are queued and written by a goroutine of
+// its own, so a client that stops reading never holds up the session.
+type connection struct {
+	conn      net.Conn
+	out       chan any
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	removes []func()
+}
+
+// endOfResponses is queued after the last response of a connection, which is closed once it is reached
+type endOfResponses struct{}
+
+func newConnection(conn net.Conn) *connection {
+	return &connection{
+		conn: conn,
+		out:  make(chan any, connectionQueueSize),
+		done: make(chan struct{}),
+	}
+}
+
+// write queues a response, waiting while the queue is full
+func (c *connection) write(v any) {
+	select {
+	case c.out <- v:
+	case <-c.done:
+	}
+}
+
+// push queues a notification without waiting. A client that lets its queue fill up is disconnected, since it would
+// silently miss messages otherwise.
+func (c *connection) push(v any) {
+	select {
+	case c.out <- v:
+	case <-c.done:
+	default:
+		c.close()
+	}
+}
+
+func (c *connection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// writeLoop writes queued responses and notifications until endOfResponses is reached or the connection is closed
+func (c *connection) writeLoop() {
+	defer c.close()
+
+	encoder := json.NewEncoder(c.conn)
+	for {
+		select {
+		case <-c.done:
+			return
+		case v := <-c.out:
+			if _, last := v.(endOfResponses); last {
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := encoder.Encode(v); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (srv *Server) handleConn(conn net.Conn) {
+	c := newConnection(conn)
+	go c.writeLoop()
+	defer func() {
+		c.mu.Lock()
+		removes := c.removes
+		c.mu.Unlock()
+		for _, remove := range removes {
+			remove()
+		}
+		// Responses still queued go out before the connection is closed
+		c.write(endOfResponses{})
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRequestSize)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			c.write(Response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &RPCError{Code: codeParseError, Message: err.Error()}})
+			continue
+		}
+
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			c.write(Response{JSONRPC: "2.0", ID: idOrNull(req.ID), Error: &RPCError{Code: codeInvalidRequest, Message: "invalid JSON-RPC 2.0 request"}})
+			continue
+		}
+
+		result, rpcErr := srv.dispatch(c, req)
+
+		// Requests without an ID are notifications and get no response
+		if len(req.ID) == 0 {
+			continue
+		}
+
+		if rpcErr != nil {
+			c.write(Response{JSONRPC: "2.0", ID: req.ID, Error: rpcErr})
+		} else {
+			c.write(Response{JSONRPC: "2.0", ID: req.ID, Result: result})
+		}
+	}
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func (srv *Server) dispatch(c *connection, req Request) (any, *RPCError) {
+	switch req.Method {
+	case "send":
+		var params SendParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return srv.send(params)
+	case "subscribe":
+		var params SubscribeParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return srv.subscribe(c, params)
+	case "list_peers":
+		var params ListPeersParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return srv.listPeers(params)
+	case "join_room":
+		var params JoinRoomParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return srv.joinRoom(params)
+	case "part_room":
+		var params JoinRoomParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return srv.partRoom(params)
+	case "moderate":
+		var params ModerateParams
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return srv.moderate(params)
+	case "list_rooms":
+		return srv.session.RoomNames(), nil
+	case "resources":
+		return srv.session.ResourceUsage(), nil
+	default:
+		return nil, &RPCError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+}
+
+func decodeParams(raw json.RawMessage, v any) *RPCError {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &RPCError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func internalError(err error) *RPCError {
+	return &RPCError{Code: codeInternalError, Message: err.Error()}
+}
+
+func (srv *Server) send(params SendParams) (any, *RPCError) {
+	if params.Text == "" {
+		return nil, &RPCError{Code: codeInvalidParams, Message: "text is required"}
+	}
+
+	if params.To != "" {
+		to, err := srv.session.DirectMessenger.Send(srv.ctx, params.To, srv.session.Username(), params.Text)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		return map[string]string{"to": to.String()}, nil
+	}
+
+	roomName := params.Room
+	if roomName == "" {
+		current := srv.session.Current()
+		if current == nil {
+			return nil, &RPCError{Code: codeInvalidParams, Message: "room is required when no room is joined"}
+		}
+		roomName = current.Name
+	}
+	roomName = client.NormalizeRoomName(roomName)
+
+	if err := srv.session.Send(srv.ctx, roomName, params.Text); err != nil {
+		return nil, internalError(err)
+	}
+	return map[string]string{"room": roomName}, nil
+}
+
+func (srv *Server) subscribe(c *connection, params SubscribeParams) (any, *RPCError) {
+	rooms := make(map[string]bool)
+	for _, roomName := range params.Rooms {
+		rooms[client.NormalizeRoomName(roomName)] = true
+	}
+
+	remove := srv.hub.subscribe(func(msg client.MessageEvent) {
+		if msg.Direct {
+			if !params.Direct && len(rooms) > 0 {
+				return
+			}
+		} else if len(rooms) > 0 && !rooms[msg.Room] {
+			return
+		}
+		c.push(Notification{JSONRPC: "2.0", Method: "message", Params: msg})
+	})
+
+	c.mu.Lock()
+	c.removes = append(c.removes, remove)
+	c.mu.Unlock()
+
+	return map[string]bool{"subscribed": true}, nil
+}
+
+func (srv *Server) listPeers(params ListPeersParams) (any, *RPCError) {
+	var peers []string
+
+	if params.Room == "" {
+		for _, p := range srv.session.Host.Network().Peers() {
+			peers = append(peers, p.String())
+		}
+		return peers, nil
+	}
+
+	room, found := srv.session.Room(client.NormalizeRoomName(params.Room))
+	if !found {
+		return nil, &RPCError{Code: codeInvalidParams, Message: client.ErrNotInRoom.Error()}
+	}
+	for _, p := range room.Peers() {
+		peers = append(peers, p.String())
+	}
+	return peers, nil
+}
+
+func (srv *Server) joinRoom(params JoinRoomParams) (any, *RPCError) {
+	if params.Room == "" {
+		return nil, &RPCError{Code: codeInvalidParams, Message: "room is required"}
+	}
+	roomName := client.NormalizeRoomName(params.Room)
+	if params.Create {
+		roomName = client.OwnedRoomName(params.Room, srv.session.Host.ID())
+	}
+
+	var roomKey *common.RoomKey
+	if params.Secret != "" {
+		key, err := common.DeriveRoomKey(roomName, params.Secret)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		roomKey = key
+	}
+
+	if _, err := srv.session.Join(roomName, roomKey); err != nil {
+		if errors.Is(err, client.ErrAlreadyInRoom) {
+			return nil, &RPCError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return nil, internalError(err)
+	}
+	return map[string]string{"room": roomName}, nil
+}
+
+func (srv *Server) partRoom(params JoinRoomParams) (any, *RPCError) {
+	roomName := client.NormalizeRoomName(params.Room)
+	if err := srv.session.Part(roomName); err != nil {
+		if errors.Is(err, client.ErrNotInRoom) {
+			return nil, &RPCError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return nil, internalError(err)
+	}
+	return map[string]string{"room": roomName}, nil
+}
+
+func (srv *Server) moderate(params ModerateParams) (any, *RPCError) {
+	if params.Room == "" || params.Action == "" || params.Target == "" {
+		return nil, &RPCError{Code: codeInvalidParams, Message: "room, action and target are required"}
+	}
+	roomName := client.NormalizeRoomName(params.Room)
+
+	var duration time.Duration
+	if params.Duration != "" {
+		d, err := time.ParseDuration(params.Duration)
+		if err != nil || d <= 0 {
+			return nil, &RPCError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid duration %q", params.Duration)}
+		}
+		duration = d
+	}
+
+	target, err := srv.session.DirectMessenger.Resolve(params.Target)
+	if err != nil {
+		return nil, &RPCError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	if err := srv.session.Moderate(roomName, params.Action, target, duration, params.Reason); err != nil {
+		if errors.Is(err, client.ErrNotInRoom) || errors.Is(err, client.ErrNotAuthorized) {
+			return nil, &RPCError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return nil, internalError(err)
+	}
+	return map[string]string{"room": roomName, "action": params.Action, "target": target.String()}, nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package blue_otter_daemon
+
+// listen_other.go contains socket creation for systems without a umask
+
+import "net"
+
+// listenPrivate creates the control socket, relying on the permissions of the directory it is created in
+func listenPrivate(socketPath string) (net.Listener, error) {
+	return net.Listen("unix", socketPath)
+}
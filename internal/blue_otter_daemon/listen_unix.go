@@ -0,0 +1,19 @@
+//go:build unix
+
+package blue_otter_daemon
+
+// listen_unix.go contains socket creation for Unix-like systems
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate creates the control socket readable and writable by the current user only. The umask is narrowed
+// while the socket is bound so it never exists with wider permissions, even briefly.
+func listenPrivate(socketPath string) (net.Listener, error) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", socketPath)
+}
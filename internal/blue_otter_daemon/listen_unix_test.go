@@ -0,0 +1,51 @@
+//go:build unix
+
+package blue_otter_daemon
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestServeSocketPermissions(t *testing.T) {
+	tests := []struct {
+		name  string
+		umask int
+	}{
+		{name: "default umask", umask: 0022},
+		{name: "permissive umask", umask: 0000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := syscall.Umask(tt.umask)
+			defer syscall.Umask(old)
+
+			socketPath := filepath.Join(t.TempDir(), "daemon.sock")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			srv, err := Serve(ctx, nil, NewEventHub(io.Discard), socketPath)
+			if err != nil {
+				t.Fatalf("Serve() error = %v", err)
+			}
+			defer srv.Close()
+
+			info, err := os.Stat(socketPath)
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if perm := info.Mode().Perm(); perm&0077 != 0 {
+				t.Errorf("socket permissions = %o, want no group or other access", perm)
+			}
+
+			if current := syscall.Umask(tt.umask); current != tt.umask {
+				t.Errorf("umask after Serve = %o, want %o", current, tt.umask)
+			}
+		})
+	}
+}
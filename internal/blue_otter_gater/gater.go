@@ -0,0 +1,212 @@
+package blue_otter_gater
+
+// gater.go contains the connection gater that enforces the peer allow and deny lists
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+// rules is the parsed form of one access list
+type rules struct {
+	peers map[peer.ID]bool
+	nets  []*net.IPNet
+}
+
+func parseRules(entries []string) (rules, error) {
+	r := rules{peers: make(map[peer.ID]bool)}
+	for _, entry := range entries {
+		id, ipNet, err := management.ParseAccessEntry(entry)
+		if err != nil {
+			return r, err
+		}
+		if ipNet != nil {
+			r.nets = append(r.nets, ipNet)
+		} else {
+			r.peers[id] = true
+		}
+	}
+	return r, nil
+}
+
+func (r rules) empty() bool {
+	return len(r.peers) == 0 && len(r.nets) == 0
+}
+
+func (r rules) matchesIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range r.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Gater is a libp2p ConnectionGater backed by the allow and deny lists in access.json.
+// Denied peers and ranges are always refused. When the allow list is not empty, only peers and ranges on it are accepted.
+type Gater struct {
+	mu    sync.RWMutex
+	allow rules
+	deny  rules
+}
+
+// Load creates a gater from the saved access lists
+func Load() (*Gater, error) {
+	g := &Gater{}
+	if err := g.Reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Reload rereads the saved access lists, picking up changes made from the command line
+func (g *Gater) Reload() error {
+	lists, err := management.LoadAccessLists()
+	if err != nil {
+		return err
+	}
+	return g.set(lists)
+}
+
+func (g *Gater) set(lists common.AccessLists) error {
+	allow, err := parseRules(lists.Allow)
+	if err != nil {
+		return fmt.Errorf("invalid allow list entry: %w", err)
+	}
+	deny, err := parseRules(lists.Deny)
+	if err != nil {
+		return fmt.Errorf("invalid deny list entry: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.allow = allow
+	g.deny = deny
+	return nil
+}
+
+// Block adds a peer ID or CIDR range to the deny list, saves it and drops any open connection it now refuses.
+// Blocking an entry that is already on the list still drops its connections.
+func (g *Gater) Block(h host.Host, entry string) (string, error) {
+	entry, err := management.AddAccessEntry(management.DenyList, entry)
+	if err != nil && !errors.Is(err, management.ErrAccessEntryExists) {
+		return entry, err
+	}
+	if err := g.Reload(); err != nil {
+		return entry, err
+	}
+	g.DisconnectRefused(h)
+	return entry, nil
+}
+
+// Unblock removes a peer ID or CIDR range from the deny list and saves it
+func (g *Gater) Unblock(entry string) (string, error) {
+	entry, err := management.RemoveAccessEntry(management.DenyList, entry)
+	if err != nil {
+		return entry, err
+	}
+	return entry, g.Reload()
+}
+
+// PeerDenied reports whether a peer ID is on the deny list. Only the peer entries are checked: the allow list and
+// CIDR ranges need an address, which a message relayed by other members of a room does not carry, so a peer refused
+// only by those may still be heard through a relay.
+func (g *Gater) PeerDenied(p peer.ID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.deny.peers[p]
+}
+
+// DisconnectRefused closes every open connection the access lists now refuse, returning how many were closed
+func (g *Gater) DisconnectRefused(h host.Host) int {
+	closed := 0
+	for _, conn := range h.Network().Conns() {
+		if !g.allowed(conn.RemotePeer(), conn.RemoteMultiaddr()) {
+			conn.Close()
+			closed++
+		}
+	}
+	return closed
+}
+
+// allowed reports whether the lists accept a peer, an address, or both. Either may be empty when not yet known.
+func (g *Gater) allowed(p peer.ID, addr multiaddr.Multiaddr) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var ip net.IP
+	if addr != nil {
+		ip, _ = manet.ToIP(addr)
+	}
+
+	if p != "" && g.deny.peers[p] {
+		return false
+	}
+	if g.deny.matchesIP(ip) {
+		return false
+	}
+
+	if g.allow.empty() {
+		return true
+	}
+	if p != "" && g.allow.peers[p] {
+		return true
+	}
+	if g.allow.matchesIP(ip) {
+		return true
+	}
+
+	// The peer of an incoming connection is only known after the handshake, so allowed peer IDs are checked then
+	return p == "" && len(g.allow.peers) > 0
+}
+
+// InterceptPeerDial refuses to dial denied peers
+func (g *Gater) InterceptPeerDial(p peer.ID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.deny.peers[p] {
+		return false
+	}
+	// With only peers on the allow list, anyone else can be refused before looking at addresses
+	if len(g.allow.nets) == 0 && len(g.allow.peers) > 0 {
+		return g.allow.peers[p]
+	}
+	return true
+}
+
+// InterceptAddrDial refuses to dial addresses in denied ranges
+func (g *Gater) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	return g.allowed(p, addr)
+}
+
+// InterceptAccept refuses incoming connections from denied ranges
+func (g *Gater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return g.allowed("", addrs.RemoteMultiaddr())
+}
+
+// InterceptSecured refuses connections once the remote peer has proven its identity
+func (g *Gater) InterceptSecured(_ network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	return g.allowed(p, addrs.RemoteMultiaddr())
+}
+
+// InterceptUpgraded accepts every connection that made it through the earlier checks
+func (g *Gater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,144 @@
+package blue_otter_gater
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+func newTestPeer(t *testing.T) peer.ID {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to derive peer ID: %v", err)
+	}
+	return id
+}
+
+func TestGaterAllowed(t *testing.T) {
+	friend := newTestPeer(t)
+	abuser := newTestPeer(t)
+	stranger := newTestPeer(t)
+
+	inRange := multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001")
+	outOfRange := multiaddr.StringCast("/ip4/192.168.1.5/tcp/4001")
+
+	tests := []struct {
+		name  string
+		lists common.AccessLists
+		peer  peer.ID
+		addr  multiaddr.Multiaddr
+		want  bool
+	}{
+		{name: "no lists", peer: stranger, addr: outOfRange, want: true},
+		{name: "denied peer", lists: common.AccessLists{Deny: []string{abuser.String()}}, peer: abuser, addr: outOfRange, want: false},
+		{name: "other peer with deny list", lists: common.AccessLists{Deny: []string{abuser.String()}}, peer: stranger, addr: outOfRange, want: true},
+		{name: "denied range", lists: common.AccessLists{Deny: []string{"10.0.0.0/8"}}, peer: stranger, addr: inRange, want: false},
+		{name: "denied range before handshake", lists: common.AccessLists{Deny: []string{"10.0.0.0/8"}}, addr: inRange, want: false},
+		{name: "denied address", lists: common.AccessLists{Deny: []string{"10.1.2.3"}}, peer: stranger, addr: inRange, want: false},
+		{name: "allowed peer", lists: common.AccessLists{Allow: []string{friend.String()}}, peer: friend, addr: outOfRange, want: true},
+		{name: "peer not on allow list", lists: common.AccessLists{Allow: []string{friend.String()}}, peer: stranger, addr: outOfRange, want: false},
+		// Incoming connections are let through to the handshake, which proves the peer ID checked against the list
+		{name: "allow list before handshake", lists: common.AccessLists{Allow: []string{friend.String()}}, addr: outOfRange, want: true},
+		{name: "allowed range", lists: common.AccessLists{Allow: []string{"10.0.0.0/8"}}, peer: stranger, addr: inRange, want: true},
+		{name: "outside allowed range", lists: common.AccessLists{Allow: []string{"10.0.0.0/8"}}, peer: stranger, addr: outOfRange, want: false},
+		{
+			name:  "deny wins over allow",
+			lists: common.AccessLists{Allow: []string{friend.String()}, Deny: []string{"10.0.0.0/8"}},
+			peer:  friend,
+			addr:  inRange,
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Gater{}
+			if err := g.set(tt.lists); err != nil {
+				t.Fatalf("set: %v", err)
+			}
+			if got := g.allowed(tt.peer, tt.addr); got != tt.want {
+				t.Errorf("allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGaterInterceptPeerDial(t *testing.T) {
+	friend := newTestPeer(t)
+	abuser := newTestPeer(t)
+	stranger := newTestPeer(t)
+
+	tests := []struct {
+		name  string
+		lists common.AccessLists
+		peer  peer.ID
+		want  bool
+	}{
+		{name: "no lists", peer: stranger, want: true},
+		{name: "denied peer", lists: common.AccessLists{Deny: []string{abuser.String()}}, peer: abuser, want: false},
+		{name: "allowed peer", lists: common.AccessLists{Allow: []string{friend.String()}}, peer: friend, want: true},
+		{name: "peer not on allow list", lists: common.AccessLists{Allow: []string{friend.String()}}, peer: stranger, want: false},
+		// With ranges on the allow list the address decides, which is only known when dialling it
+		{name: "allowed range", lists: common.AccessLists{Allow: []string{"10.0.0.0/8"}}, peer: stranger, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Gater{}
+			if err := g.set(tt.lists); err != nil {
+				t.Fatalf("set: %v", err)
+			}
+			if got := g.InterceptPeerDial(tt.peer); got != tt.want {
+				t.Errorf("InterceptPeerDial() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGaterPeerDenied(t *testing.T) {
+	friend := newTestPeer(t)
+	abuser := newTestPeer(t)
+	stranger := newTestPeer(t)
+
+	tests := []struct {
+		name  string
+		lists common.AccessLists
+		peer  peer.ID
+		want  bool
+	}{
+		{name: "no lists", peer: stranger, want: false},
+		{name: "denied peer", lists: common.AccessLists{Deny: []string{abuser.String()}}, peer: abuser, want: true},
+		{name: "other peer with deny list", lists: common.AccessLists{Deny: []string{abuser.String()}}, peer: stranger, want: false},
+		// Only peer entries are checked, ranges and the allow list need an address
+		{name: "denied range", lists: common.AccessLists{Deny: []string{"10.0.0.0/8"}}, peer: stranger, want: false},
+		{name: "peer not on allow list", lists: common.AccessLists{Allow: []string{friend.String()}}, peer: stranger, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Gater{}
+			if err := g.set(tt.lists); err != nil {
+				t.Fatalf("set: %v", err)
+			}
+			if got := g.PeerDenied(tt.peer); got != tt.want {
+				t.Errorf("PeerDenied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGaterInvalidEntry(t *testing.T) {
+	g := &Gater{}
+	if err := g.set(common.AccessLists{Deny: []string{"not-an-entry"}}); err == nil {
+		t.Error("set() accepted an entry that is neither a peer ID nor an address")
+	}
+}
@@ -0,0 +1,206 @@
+package blue_otter_management
+
+// access.go contains all file operations for the peer allow and deny lists
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// Access lists stored in access.json
+const (
+	AllowList = "allow"
+	DenyList  = "deny"
+)
+
+var (
+	// ErrAccessEntryExists is returned when adding an entry that is already on the list
+	ErrAccessEntryExists = errors.New("entry already on the list")
+	// ErrAccessEntryNotFound is returned when removing an entry that is not on the list
+	ErrAccessEntryNotFound = errors.New("entry not on the list")
+)
+
+// accessMu serializes changes to the access lists, which are read, modified and saved again as a whole
+var accessMu sync.Mutex
+
+// GetAccessFilePath returns the path to the access.json file
+func GetAccessFilePath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "access.json"), nil
+}
+
+// ParseAccessEntry parses an access list entry, which is either a peer ID or a CIDR range.
+// A bare IP address is treated as a range holding just that address.
+func ParseAccessEntry(entry string) (peer.ID, *net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+
+	if id, err := peer.Decode(entry); err == nil {
+		return id, nil, nil
+	}
+
+	if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+		return "", ipNet, nil
+	}
+
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return "", &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	return "", nil, fmt.Errorf("%q is neither a peer ID nor an IP address or CIDR range", entry)
+}
+
+// normalizeAccessEntry returns the canonical form of an entry so the same peer or range is only stored once
+func normalizeAccessEntry(entry string) (string, error) {
+	id, ipNet, err := ParseAccessEntry(entry)
+	if err != nil {
+		return "", err
+	}
+	if ipNet != nil {
+		return ipNet.String(), nil
+	}
+	return id.String(), nil
+}
+
+// LoadAccessLists loads the allow and deny lists. Both are empty when none were saved.
+func LoadAccessLists() (common.AccessLists, error) {
+	var lists common.AccessLists
+
+	filePath, err := GetAccessFilePath()
+	if err != nil {
+		return lists, err
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return lists, nil
+	}
+	if err != nil {
+		return lists, fmt.Errorf("failed to read access lists: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return lists, fmt.Errorf("access lists at %s are corrupt: %w", filePath, err)
+	}
+
+	return lists, nil
+}
+
+// SaveAccessLists writes the allow and deny lists
+func SaveAccessLists(lists common.AccessLists) error {
+	if err := EnsureConfigDir(); err != nil {
+		return err
+	}
+
+	filePath, err := GetAccessFilePath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(lists, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filePath, data, 0600)
+}
+
+// AddAccessEntry adds a peer ID or CIDR range to the allow or deny list.
+// Denying an entry removes it from the allow list and allowing it removes it from the deny list.
+func AddAccessEntry(list string, entry string) (string, error) {
+	entry, err := normalizeAccessEntry(entry)
+	if err != nil {
+		return "", err
+	}
+
+	accessMu.Lock()
+	defer accessMu.Unlock()
+
+	lists, err := LoadAccessLists()
+	if err != nil {
+		return "", err
+	}
+
+	target, other := accessListPair(&lists, list)
+	if target == nil {
+		return "", fmt.Errorf("unknown access list %q", list)
+	}
+
+	for _, existing := range *target {
+		if existing == entry {
+			return entry, ErrAccessEntryExists
+		}
+	}
+
+	*target = append(*target, entry)
+	*other = removeEntry(*other, entry)
+
+	return entry, SaveAccessLists(lists)
+}
+
+// RemoveAccessEntry removes a peer ID or CIDR range from the allow or deny list
+func RemoveAccessEntry(list string, entry string) (string, error) {
+	entry, err := normalizeAccessEntry(entry)
+	if err != nil {
+		return "", err
+	}
+
+	accessMu.Lock()
+	defer accessMu.Unlock()
+
+	lists, err := LoadAccessLists()
+	if err != nil {
+		return "", err
+	}
+
+	target, _ := accessListPair(&lists, list)
+	if target == nil {
+		return "", fmt.Errorf("unknown access list %q", list)
+	}
+
+	remaining := removeEntry(*target, entry)
+	if len(remaining) == len(*target) {
+		return entry, ErrAccessEntryNotFound
+	}
+	*target = remaining
+
+	return entry, SaveAccessLists(lists)
+}
+
+// accessListPair returns the named list and the opposite one
+func accessListPair(lists *common.AccessLists, list string) (*[]string, *[]string) {
+	switch list {
+	case AllowList:
+		return &lists.Allow, &lists.Deny
+	case DenyList:
+		return &lists.Deny, &lists.Allow
+	default:
+		return nil, nil
+	}
+}
+
+func removeEntry(entries []string, entry string) []string {
+	remaining := make([]string, 0, len(entries))
+	for _, existing := range entries {
+		if existing != entry {
+			remaining = append(remaining, existing)
+		}
+	}
+	return remaining
+}
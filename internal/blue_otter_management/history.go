@@ -0,0 +1,285 @@
+package blue_otter_management
+
+// history.go contains all local message history file operations for the application
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// HistoryEnd is the offset LoadHistoryPage starts from to page back from the end of a room's log
+const HistoryEnd int64 = -1
+
+// historyChunkSize is how much of a log LoadHistoryPage reads at a time
+const historyChunkSize int64 = 64 * 1024
+
+const (
+	// MaxHistoryMessages is how many of the most recent messages a room's log keeps when it is compacted
+	MaxHistoryMessages = 2000
+	// maxHistorySize is how large a room's log may grow before it is compacted. Compaction keeps at most half of it,
+	// so a log of very large messages is not compacted again on every append.
+	maxHistorySize int64 = 4 * 1024 * 1024
+)
+
+// historyMu serialises appends with compaction, which replaces the log file
+var historyMu sync.Mutex
+
+// GetHistoryDir returns the path to the directory holding per-room message logs
+func GetHistoryDir() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "history"), nil
+}
+
+// GetHistoryFilePath returns the path to the append-only message log of a room.
+// Room names are hashed so that arbitrary names are safe as file names and are not exposed on disk.
+func GetHistoryFilePath(roomName string) (string, error) {
+	historyDir, err := GetHistoryDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(historyDir, roomFileName(roomName)), nil
+}
+
+// roomFileName returns the file name of a per-room log, hashed so arbitrary room names are safe and not exposed on disk
+func roomFileName(roomName string) string {
+	sum := sha256.Sum256([]byte(roomName))
+	return hex.EncodeToString(sum[:16]) + ".jsonl"
+}
+
+// HasRoomLogs reports whether this node keeps message history or a moderation log for a room, which it only does
+// for rooms it has been in
+func HasRoomLogs(roomName string) (bool, error) {
+	historyPath, err := GetHistoryFilePath(roomName)
+	if err != nil {
+		return false, err
+	}
+	moderationPath, err := GetModerationFilePath(roomName)
+	if err != nil {
+		return false, err
+	}
+
+	for _, filePath := range []string{historyPath, moderationPath} {
+		if _, err := os.Stat(filePath); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to check room logs: %w", err)
+		}
+	}
+	return false, nil
+}
+
+// AppendHistory appends an envelope, exactly as it was received, to the log of a room. Once the log grows past
+// maxHistorySize it is compacted down to the newest MaxHistoryMessages messages, and the number of bytes dropped from
+// its start is returned so offsets from LoadHistoryPage can be moved along.
+func AppendHistory(roomName string, env common.Envelope) (int64, error) {
+	historyDir, err := GetHistoryDir()
+	if err != nil {
+		return 0, err
+	}
+
+	filePath, err := GetHistoryFilePath(roomName)
+	if err != nil {
+		return 0, err
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	if err := appendEnvelope(historyDir, filePath, env); err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read log file: %w", err)
+	}
+	if info.Size() <= maxHistorySize {
+		return 0, nil
+	}
+	return compactHistory(filePath, info.Size())
+}
+
+// compactHistory rewrites the log at filePath with only its newest messages, returning how many bytes were dropped
+func compactHistory(filePath string, size int64) (int64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close()
+
+	kept := 0
+	start, err := scanLinesBackwards(f, size, func(line []byte, offset int64) bool {
+		if kept == MaxHistoryMessages || size-offset > maxHistorySize/2 {
+			return false
+		}
+		kept++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".compact-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact log file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, io.NewSectionReader(f, start, size-start))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact log file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return 0, fmt.Errorf("failed to compact log file: %w", err)
+	}
+	return start, nil
+}
+
+// appendEnvelope appends an envelope as a single line to the log at filePath, creating dir if needed
+func appendEnvelope(dir string, filePath string, env common.Envelope) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := common.EncodeEnvelope(env)
+	if err != nil {
+		return fmt.Errorf("failed to encode log entry: %w", err)
+	}
+
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write log entry: %w", err)
+	}
+
+	return nil
+}
+
+// loadEnvelopes loads every envelope of the log at filePath in the order they were appended. Corrupt lines are skipped.
+func loadEnvelopes(filePath string) ([]common.Envelope, error) {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close()
+
+	var envelopes []common.Envelope
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var env common.Envelope
+		if err := json.Unmarshal(scanner.Bytes(), &env); err != nil {
+			continue
+		}
+		envelopes = append(envelopes, env)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return envelopes, fmt.Errorf("failed to read log file: %w", err)
+	}
+
+	return envelopes, nil
+}
+
+// scanLinesBackwards calls fn with every non-empty line of f that ends before the byte offset before, newest first,
+// along with the offset the line starts at. It stops when fn returns false and returns the offset of the oldest line
+// fn accepted, or before when it accepted none.
+func scanLinesBackwards(f *os.File, before int64, fn func(line []byte, offset int64) bool) (int64, error) {
+	// pending holds the bytes from pos up to end that are not split into lines yet. end is always the start of a line.
+	var pending []byte
+	pos, end := before, before
+	for end > 0 {
+		body := bytes.TrimSuffix(pending, []byte("\n"))
+		i := bytes.LastIndexByte(body, '\n')
+		if i < 0 && pos > 0 {
+			n := min(historyChunkSize, pos)
+			pos -= n
+			chunk := make([]byte, n, int(n)+len(pending))
+			if _, err := f.ReadAt(chunk, pos); err != nil {
+				return before, fmt.Errorf("failed to read log file: %w", err)
+			}
+			pending = append(chunk, pending...)
+			continue
+		}
+
+		start := pos + int64(i+1)
+		if line := body[i+1:]; len(line) > 0 && !fn(line, start) {
+			break
+		}
+		pending = pending[:i+1]
+		end = start
+	}
+	return end, nil
+}
+
+// LoadHistoryPage returns up to limit envelopes for a room that were stored before the byte offset before, oldest first,
+// and the offset the next older page continues from. HistoryEnd pages back from the current end of the log. Only the
+// part of the log that is paged through is read.
+func LoadHistoryPage(roomName string, before int64, limit int) ([]common.Envelope, int64, error) {
+	filePath, err := GetHistoryFilePath(roomName)
+	if err != nil {
+		return nil, before, err
+	}
+
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, before, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, before, fmt.Errorf("failed to read log file: %w", err)
+	}
+	if before == HistoryEnd || before > info.Size() {
+		before = info.Size()
+	}
+
+	var page []common.Envelope
+	end, err := scanLinesBackwards(f, before, func(line []byte, _ int64) bool {
+		if len(page) == limit {
+			return false
+		}
+		var env common.Envelope
+		if json.Unmarshal(line, &env) == nil {
+			page = append(page, env)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, before, err
+	}
+
+	// Lines were read newest first
+	for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+		page[i], page[j] = page[j], page[i]
+	}
+	return page, end, nil
+}
@@ -0,0 +1,175 @@
+package blue_otter_management
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// appendTestHistory appends n messages to roomName, numbered from first, each padded to roughly size bytes
+func appendTestHistory(t *testing.T, roomName string, first int, n int, size int) int64 {
+	t.Helper()
+	var dropped int64
+	for i := first; i < first+n; i++ {
+		env, err := common.NewEnvelope(common.KindChat, "sender", common.ChatMessage{Sender: "Alice", Text: strings.Repeat("x", size)})
+		if err != nil {
+			t.Fatalf("NewEnvelope: %v", err)
+		}
+		env.ID = fmt.Sprint(i)
+		d, err := AppendHistory(roomName, env)
+		if err != nil {
+			t.Fatalf("AppendHistory: %v", err)
+		}
+		dropped += d
+	}
+	return dropped
+}
+
+func TestLoadHistoryPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages int
+		size     int
+		limit    int
+	}{
+		{name: "empty log", messages: 0, size: 10, limit: 10},
+		{name: "single page", messages: 5, size: 10, limit: 10},
+		{name: "exact pages", messages: 20, size: 10, limit: 5},
+		{name: "one at a time", messages: 7, size: 10, limit: 1},
+		// Messages of about 1 KiB span many 64 KiB read chunks, and lines straddle chunk boundaries
+		{name: "larger than a chunk", messages: 300, size: 1000, limit: 7},
+		{name: "whole log larger than a chunk", messages: 300, size: 1000, limit: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			appendTestHistory(t, "room", 0, tt.messages, tt.size)
+
+			// Page back from the end the way scrollback does, newest page first
+			var ids []string
+			before := HistoryEnd
+			for pages := 0; ; pages++ {
+				if pages > tt.messages+1 {
+					t.Fatal("paging never reached the start of the log")
+				}
+				page, next, err := LoadHistoryPage("room", before, tt.limit)
+				if err != nil {
+					t.Fatalf("LoadHistoryPage: %v", err)
+				}
+				if len(page) > tt.limit {
+					t.Fatalf("page of %d messages, limit %d", len(page), tt.limit)
+				}
+				if len(page) == 0 {
+					break
+				}
+
+				var pageIDs []string
+				for _, env := range page {
+					pageIDs = append(pageIDs, env.ID)
+				}
+				ids = append(pageIDs, ids...)
+
+				// A message stored while paging belongs after the first page and must not show up in later ones
+				if before == HistoryEnd {
+					appendTestHistory(t, "room", tt.messages, 1, tt.size)
+				}
+				before = next
+			}
+
+			if len(ids) != tt.messages {
+				t.Fatalf("paged through %d messages, want %d", len(ids), tt.messages)
+			}
+			for i, id := range ids {
+				if id != fmt.Sprint(i) {
+					t.Fatalf("message %d has ID %s, want messages in the order they were stored", i, id)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadHistoryPageSkipsCorruptLines(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	appendTestHistory(t, "room", 0, 2, 10)
+
+	filePath, err := GetHistoryFilePath("room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.Close()
+	appendTestHistory(t, "room", 2, 1, 10)
+
+	page, _, err := LoadHistoryPage("room", HistoryEnd, 10)
+	if err != nil {
+		t.Fatalf("LoadHistoryPage: %v", err)
+	}
+	if len(page) != 3 || page[0].ID != "0" || page[2].ID != "2" {
+		t.Errorf("LoadHistoryPage() returned %d messages, want the 3 valid ones in order", len(page))
+	}
+}
+
+func TestAppendHistoryCompacts(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		// byCount is set when the message count rather than the size limits what compaction keeps
+		byCount bool
+	}{
+		{name: "small messages are capped by count", size: 200, byCount: true},
+		{name: "large messages are capped by size", size: 4000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+
+			// Append until the log passes maxHistorySize and is compacted
+			total := 0
+			for dropped := int64(0); dropped == 0; total++ {
+				if total > int(maxHistorySize) {
+					t.Fatal("log was never compacted")
+				}
+				dropped = appendTestHistory(t, "room", total, 1, tt.size)
+			}
+
+			filePath, err := GetHistoryFilePath("room")
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() > maxHistorySize/2 {
+				t.Errorf("log is %d bytes after compaction, want at most %d", info.Size(), maxHistorySize/2)
+			}
+
+			page, _, err := LoadHistoryPage("room", HistoryEnd, total)
+			if err != nil {
+				t.Fatalf("LoadHistoryPage: %v", err)
+			}
+			if tt.byCount && len(page) != MaxHistoryMessages {
+				t.Errorf("kept %d messages, want %d", len(page), MaxHistoryMessages)
+			}
+			if !tt.byCount && (len(page) >= MaxHistoryMessages || len(page) == 0) {
+				t.Errorf("kept %d messages, want fewer than %d", len(page), MaxHistoryMessages)
+			}
+
+			// The newest messages survive, in the order they were stored
+			for i, env := range page {
+				if want := fmt.Sprint(total - len(page) + i); env.ID != want {
+					t.Fatalf("kept message %d has ID %s, want %s", i, env.ID, want)
+				}
+			}
+		})
+	}
+}
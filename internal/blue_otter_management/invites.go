@@ -0,0 +1,82 @@
+package blue_otter_management
+
+// invites.go contains all file operations for the keys of invite-only rooms
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// GetInvitesFilePath returns the path to the invites.json file holding the keys of invite-only rooms
+func GetInvitesFilePath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "invites.json"), nil
+}
+
+// loadInviteKeys loads the keys of every invite-only room this node is a member of, by room name
+func loadInviteKeys() (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+
+	filePath, err := GetInvitesFilePath()
+	if err != nil {
+		return keys, err
+	}
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return keys, fmt.Errorf("failed to read invite-only room keys: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return keys, fmt.Errorf("invite-only room keys at %s are corrupt: %w", filePath, err)
+	}
+
+	return keys, nil
+}
+
+// LoadInviteKey returns the key of an invite-only room, or nil when this node was never invited to it
+func LoadInviteKey(roomName string) ([]byte, error) {
+	keys, err := loadInviteKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys[roomName], nil
+}
+
+// SaveInviteKey stores the key of an invite-only room, replacing any earlier key for the same room
+func SaveInviteKey(roomName string, key []byte) error {
+	keys, err := loadInviteKeys()
+	if err != nil {
+		return err
+	}
+	keys[roomName] = key
+
+	if err := EnsureConfigDir(); err != nil {
+		return err
+	}
+
+	filePath, err := GetInvitesFilePath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode invite-only room keys: %w", err)
+	}
+
+	// Anyone holding a room key can read and post in the room, so keep it private
+	if err := os.WriteFile(filePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write invite-only room keys: %w", err)
+	}
+
+	return nil
+}
@@ -34,6 +34,24 @@ func GetBootstrapFilePath() (string, error) {
 	return filepath.Join(configDir, "bootstrap.json"), nil
 }
 
+// GetDaemonSocketPath returns the default path of the daemon control socket
+func GetDaemonSocketPath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "daemon.sock"), nil
+}
+
+// GetDatastorePath returns the path of the datastore holding the peerstore and DHT records
+func GetDatastorePath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "datastore"), nil
+}
+
 // EnsureConfigDir ensures the config directory exists
 func EnsureConfigDir() error {
 	configDir, err := GetConfigDir()
@@ -116,7 +134,7 @@ func GetPrivateKey() (crypto.PrivKey, error) {
 
 	var info common.BootstrapInfo
 	if err := json.Unmarshal(data, &info); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s: %v", common.ErrKeyCorrupt, configPath, err)
 	}
 
 	if info.PrivateKey == "" {
@@ -125,12 +143,12 @@ func GetPrivateKey() (crypto.PrivKey, error) {
 
 	privateKeyData, err := base64.StdEncoding.DecodeString(info.PrivateKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode private key: %w", err)
+		return nil, fmt.Errorf("%w: failed to decode private key in %s: %v", common.ErrKeyCorrupt, configPath, err)
 	}
 
 	privateKey, err := crypto.UnmarshalPrivateKey(privateKeyData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+		return nil, fmt.Errorf("%w: failed to unmarshal private key in %s: %v", common.ErrKeyCorrupt, configPath, err)
 	}
 
 	return privateKey, nil
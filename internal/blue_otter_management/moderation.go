@@ -0,0 +1,51 @@
+package blue_otter_management
+
+// moderation.go contains all file operations for the moderation logs of rooms
+
+import (
+	"path/filepath"
+
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// GetModerationDir returns the path to the directory holding per-room moderation logs
+func GetModerationDir() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "moderation"), nil
+}
+
+// GetModerationFilePath returns the path to the append-only moderation log of a room
+func GetModerationFilePath(roomName string) (string, error) {
+	moderationDir, err := GetModerationDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(moderationDir, roomFileName(roomName)), nil
+}
+
+// AppendModerationLog appends a signed moderation envelope, exactly as it was received, to the log of a room
+func AppendModerationLog(roomName string, env common.Envelope) error {
+	moderationDir, err := GetModerationDir()
+	if err != nil {
+		return err
+	}
+
+	filePath, err := GetModerationFilePath(roomName)
+	if err != nil {
+		return err
+	}
+
+	return appendEnvelope(moderationDir, filePath, env)
+}
+
+// LoadModerationLog loads every moderation envelope stored for a room, in the order they were applied
+func LoadModerationLog(roomName string) ([]common.Envelope, error) {
+	filePath, err := GetModerationFilePath(roomName)
+	if err != nil {
+		return nil, err
+	}
+	return loadEnvelopes(filePath)
+}
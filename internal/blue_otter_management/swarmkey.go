@@ -0,0 +1,169 @@
+package blue_otter_management
+
+// swarmkey.go contains all file operations for the pre-shared key of a private network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/multiformats/go-multistream"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+)
+
+// swarmKeyHeader is the header of the swarm.key format shared with other libp2p applications
+const swarmKeyHeader = "/key/swarm/psk/1.0.0/\n/base16/\n"
+
+var (
+	// ErrSwarmKeyExists is returned when generating or importing a swarm key would overwrite an existing one
+	ErrSwarmKeyExists = errors.New("a swarm key already exists")
+	// ErrNoSwarmKey is returned when exporting a swarm key that was never generated or imported
+	ErrNoSwarmKey = errors.New("no swarm key configured")
+	// ErrSwarmKeyMismatch is returned when a connection fails because the peers are not in the same private network
+	ErrSwarmKeyMismatch = errors.New("handshake failed: swarm key mismatch")
+)
+
+// GetSwarmKeyPath returns the path to the swarm.key file
+func GetSwarmKeyPath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "swarm.key"), nil
+}
+
+// LoadSwarmKey loads the pre-shared key of the private network. It returns nil when no key is configured.
+func LoadSwarmKey() (pnet.PSK, error) {
+	keyPath, err := GetSwarmKeyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swarm key: %w", err)
+	}
+
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: swarm key at %s: %v", common.ErrKeyCorrupt, keyPath, err)
+	}
+	return psk, nil
+}
+
+// GenerateSwarmKey creates a new random swarm key. An existing key is only replaced when force is set.
+func GenerateSwarmKey(force bool) (pnet.PSK, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate swarm key: %w", err)
+	}
+
+	data := []byte(swarmKeyHeader + hex.EncodeToString(key) + "\n")
+	if err := writeSwarmKey(data, force); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ImportSwarmKey validates and stores a swarm key in the swarm.key format. An existing key is only replaced when force is set.
+func ImportSwarmKey(data []byte, force bool) (pnet.PSK, error) {
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid swarm key: %w", err)
+	}
+
+	if err := writeSwarmKey(data, force); err != nil {
+		return nil, err
+	}
+	return psk, nil
+}
+
+// ExportSwarmKey returns the stored swarm key in the swarm.key format, ready to be shared with other members
+func ExportSwarmKey() ([]byte, error) {
+	keyPath, err := GetSwarmKeyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		return nil, ErrNoSwarmKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swarm key: %w", err)
+	}
+	return data, nil
+}
+
+// RemoveSwarmKey deletes the stored swarm key, returning to the public network
+func RemoveSwarmKey() error {
+	keyPath, err := GetSwarmKeyPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(keyPath); os.IsNotExist(err) {
+		return ErrNoSwarmKey
+	} else if err != nil {
+		return fmt.Errorf("failed to remove swarm key: %w", err)
+	}
+	return nil
+}
+
+func writeSwarmKey(data []byte, force bool) error {
+	if err := EnsureConfigDir(); err != nil {
+		return err
+	}
+
+	keyPath, err := GetSwarmKeyPath()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(keyPath); err == nil && !force {
+		return ErrSwarmKeyExists
+	}
+
+	if err := os.WriteFile(keyPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write swarm key: %w", err)
+	}
+	return nil
+}
+
+// SwarmKeyFingerprint returns a short identifier of a swarm key that is safe to show and compare
+func SwarmKeyFingerprint(psk pnet.PSK) string {
+	sum := sha256.Sum256(psk)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ExplainHandshakeError turns a failed dial caused by a swarm key mismatch into ErrSwarmKeyMismatch.
+// Only a private network is affected: when both peers use a pre-shared key but not the same one, each decrypts the
+// other's security negotiation into garbage, which is the only failure explained here. Any other error, including a
+// peer simply closing the connection, is returned unchanged.
+func ExplainHandshakeError(err error, private bool) error {
+	if err == nil || !private || !isGarbledNegotiation(err) {
+		return err
+	}
+	return fmt.Errorf("%w: the peer is not using our swarm key, compare fingerprints printed at startup (%v)", ErrSwarmKeyMismatch, err)
+}
+
+// isGarbledNegotiation reports whether err is a security negotiation that failed on a malformed message
+func isGarbledNegotiation(err error) bool {
+	if !strings.Contains(err.Error(), "failed to negotiate security protocol") {
+		return false
+	}
+	return errors.Is(err, multistream.ErrTooLarge) ||
+		errors.Is(err, multistream.ErrIncorrectVersion) ||
+		// multistream has no exported error for a message of plausible length with a garbled end
+		strings.Contains(err.Error(), "message did not have trailing newline")
+}
@@ -0,0 +1,54 @@
+package blue_otter_management
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/multiformats/go-multistream"
+)
+
+// negotiationError wraps cause the way the libp2p upgrader and swarm report a failed dial
+func negotiationError(cause error) error {
+	return fmt.Errorf("failed to dial: all dials failed: failed to negotiate security protocol: %w", cause)
+}
+
+func TestExplainHandshakeError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		private  bool
+		mismatch bool
+	}{
+		{name: "nil", err: nil, private: true},
+		{name: "public garbled negotiation", err: negotiationError(multistream.ErrTooLarge), private: false},
+		{name: "public connection reset", err: negotiationError(syscall.ECONNRESET), private: false},
+		{name: "public EOF", err: negotiationError(io.EOF), private: false},
+		{name: "private message too large", err: negotiationError(multistream.ErrTooLarge), private: true, mismatch: true},
+		{name: "private incorrect version", err: negotiationError(multistream.ErrIncorrectVersion), private: true, mismatch: true},
+		{name: "private no trailing newline", err: negotiationError(errors.New("message did not have trailing newline")), private: true, mismatch: true},
+		{name: "private connection reset", err: negotiationError(syscall.ECONNRESET), private: true},
+		{name: "private EOF", err: negotiationError(io.EOF), private: true},
+		{name: "private peer sent no nonce", err: negotiationError(pnet.NewError("could not read full nonce")), private: true},
+		{name: "private too large outside security negotiation", err: fmt.Errorf("failed to open stream: %w", multistream.ErrTooLarge), private: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExplainHandshakeError(tt.err, tt.private)
+			if errors.Is(got, ErrSwarmKeyMismatch) != tt.mismatch {
+				t.Fatalf("ExplainHandshakeError() = %v, want mismatch %v", got, tt.mismatch)
+			}
+			if !tt.mismatch && got != tt.err {
+				t.Errorf("ExplainHandshakeError() = %v, want the error unchanged", got)
+			}
+			if tt.mismatch && !strings.Contains(got.Error(), tt.err.Error()) {
+				t.Errorf("ExplainHandshakeError() = %v, want the original error in the message", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package blue_otter_store
+
+// store.go contains the on-disk datastore that keeps the peerstore and DHT records across restarts
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	libp2p "github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
+	common "github.com/patrickma6199/blue-otter/internal/blue_otter_common"
+	management "github.com/patrickma6199/blue-otter/internal/blue_otter_management"
+)
+
+const (
+	// KnownPeerTTL is how long the addresses of a peer are remembered after we were last connected to it
+	KnownPeerTTL = 7 * 24 * time.Hour
+	// rememberDelay is how long after startup connected peers are first remembered, once the node had time to connect
+	rememberDelay = 30 * time.Second
+	// rememberInterval is how often connected peers are remembered afterwards
+	rememberInterval = 5 * time.Minute
+	// warmPeerLimit bounds how many remembered peers are dialed at startup
+	warmPeerLimit = 16
+	// warmDialTimeout bounds dialing remembered peers at startup
+	warmDialTimeout = 10 * time.Second
+)
+
+// certifiedPeerstore is a peerstore that can also store signed peer records, which libp2p requires
+type certifiedPeerstore interface {
+	peerstore.Peerstore
+	peerstore.CertifiedAddrBook
+}
+
+// Store is the datastore behind the peerstore and the DHT of a node
+type Store struct {
+	datastore datastore.Batching
+	peerstore certifiedPeerstore
+	// Persistent is false when the store is kept in memory and forgotten on exit
+	Persistent bool
+}
+
+// closingPeerstore closes the datastore along with the peerstore, which libp2p does when the host is closed or
+// fails to start
+type closingPeerstore struct {
+	certifiedPeerstore
+	datastore datastore.Batching
+}
+
+func (ps closingPeerstore) Close() error {
+	err := ps.certifiedPeerstore.Close()
+	if closeErr := ps.datastore.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Open opens the datastore under ~/.blue-otter/datastore. Only one process can hold it at a time.
+func Open(ctx context.Context) (*Store, error) {
+	if err := management.EnsureConfigDir(); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+	path, err := management.GetDatastorePath()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := leveldb.NewDatastore(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open datastore %s: %w", path, err)
+	}
+	return newStore(ctx, d, true)
+}
+
+// OpenMemory creates a store kept in memory, for when the datastore on disk cannot be opened
+func OpenMemory(ctx context.Context) (*Store, error) {
+	return newStore(ctx, dssync.MutexWrap(datastore.NewMapDatastore()), false)
+}
+
+func newStore(ctx context.Context, d datastore.Batching, persistent bool) (*Store, error) {
+	ps, err := pstoreds.NewPeerstore(ctx, d, pstoreds.DefaultOpts())
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("failed to open peerstore: %w", err)
+	}
+	return &Store{datastore: d, peerstore: closingPeerstore{certifiedPeerstore: ps, datastore: d}, Persistent: persistent}, nil
+}
+
+// Options returns the libp2p options using the store for the peerstore. The store is closed with the host.
+func (s *Store) Options() []libp2p.Option {
+	return []libp2p.Option{libp2p.Peerstore(s.peerstore)}
+}
+
+// DHTOptions returns the DHT options keeping provider records and values in the store
+func (s *Store) DHTOptions() []dht.Option {
+	return []dht.Option{dht.Datastore(s.datastore)}
+}
+
+// Close closes the store of a host that failed to start. The host closes it itself otherwise.
+func (s *Store) Close() error {
+	return s.peerstore.Close()
+}
+
+// Reconnect dials up to warmPeerLimit remembered peers, DHT servers first, and returns how many it reached. Peers
+// the configured bootstrap nodes would have introduced us to are reachable this way even while those are down.
+func (s *Store) Reconnect(ctx context.Context, h host.Host) int {
+	var servers, others []peer.ID
+	for _, p := range h.Peerstore().PeersWithAddrs() {
+		if p == h.ID() || h.Network().Connectedness(p) == network.Connected {
+			continue
+		}
+		if supported, _ := h.Peerstore().SupportsProtocols(p, common.DHTProtocol); len(supported) > 0 {
+			servers = append(servers, p)
+		} else {
+			others = append(others, p)
+		}
+	}
+	rand.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+	rand.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+
+	candidates := append(servers, others...)
+	if len(candidates) > warmPeerLimit {
+		candidates = candidates[:warmPeerLimit]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, warmDialTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reached := 0
+	for _, p := range candidates {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if err := h.Connect(ctx, peer.AddrInfo{ID: p}); err == nil {
+				mu.Lock()
+				reached++
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	return reached
+}
+
+// Remember keeps the addresses of connected peers for KnownPeerTTL, saving them shortly after startup and then every
+// rememberInterval until ctx is done. Addresses libp2p learns on its own are forgotten minutes after disconnecting.
+func (s *Store) Remember(ctx context.Context, h host.Host) {
+	wait := rememberDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = rememberInterval
+
+		for _, p := range h.Network().Peers() {
+			if addrs := h.Peerstore().Addrs(p); len(addrs) > 0 {
+				h.Peerstore().SetAddrs(p, addrs, KnownPeerTTL)
+			}
+		}
+	}
+}
@@ -4,14 +4,221 @@ package blue_otter_tui
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/gdamore/tcell/v2"
+	client "github.com/patrickma6199/blue-otter/internal/blue_otter_client"
 	"github.com/rivo/tview"
 )
 
+// ChatPages holds a separate chat pane, and so a separate scrollback buffer, for each joined room
+type ChatPages struct {
+	*tview.Pages
+
+	mu      sync.Mutex
+	views   map[string]*tview.TextView
+	active  string
+	changed func(view *tview.TextView)
+}
+
+// NewChatPages creates an empty set of chat panes
+func NewChatPages() *ChatPages {
+	return &ChatPages{
+		Pages: tview.NewPages(),
+		views: make(map[string]*tview.TextView),
+	}
+}
+
+// SetChangedFunc sets the handler called whenever any chat pane, current or future, receives new text
+func (cp *ChatPages) SetChangedFunc(handler func(view *tview.TextView)) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.changed = handler
+	for _, view := range cp.views {
+		cp.watch(view)
+	}
+}
+
+func (cp *ChatPages) watch(view *tview.TextView) {
+	if cp.changed == nil {
+		return
+	}
+	handler := cp.changed
+	view.SetChangedFunc(func() {
+		handler(view)
+	})
+}
+
+// View returns the chat pane for a room, creating it if needed. An empty room name returns the active pane.
+func (cp *ChatPages) View(roomName string) *tview.TextView {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if roomName == "" {
+		roomName = cp.active
+	}
+
+	if view, found := cp.views[roomName]; found {
+		return view
+	}
+
+	title := " Chat "
+	if roomName != "" {
+		title = fmt.Sprintf(" Chat - %s ", roomName)
+	}
+
+	view := tview.NewTextView()
+	view.SetTitle(title).
+		SetBorder(true)
+
+	view.SetTextColor(tcell.ColorWhite)
+
+	cp.watch(view)
+	cp.views[roomName] = view
+	cp.AddPage(roomName, view, true, cp.active == "")
+	if cp.active == "" {
+		cp.active = roomName
+	}
+
+	return view
+}
+
+// Lookup returns the chat pane of a room without creating it. An empty room name returns the active pane.
+func (cp *ChatPages) Lookup(roomName string) (*tview.TextView, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if roomName == "" {
+		roomName = cp.active
+	}
+	view, found := cp.views[roomName]
+	return view, found
+}
+
+// Active returns the name of the room whose pane is shown
+func (cp *ChatPages) Active() string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return cp.active
+}
+
+// Switch shows the chat pane for a room
+func (cp *ChatPages) Switch(roomName string) {
+	cp.View(roomName)
+
+	cp.mu.Lock()
+	cp.active = roomName
+	cp.mu.Unlock()
+
+	cp.SwitchToPage(roomName)
+}
+
+// Remove discards the chat pane and scrollback of a room
+func (cp *ChatPages) Remove(roomName string) {
+	cp.mu.Lock()
+	delete(cp.views, roomName)
+	if cp.active == roomName {
+		cp.active = ""
+	}
+	cp.mu.Unlock()
+
+	cp.RemovePage(roomName)
+}
+
+// UserList shows the roster of the active room. Rosters of the other joined rooms are kept for when they are switched to.
+type UserList struct {
+	*tview.TextView
+
+	mu      sync.Mutex
+	rosters map[string][]client.Member
+	active  string
+}
+
+// NewUserList creates an empty user list pane
+func NewUserList() *UserList {
+	view := tview.NewTextView()
+	view.SetTitle(" Users ").
+		SetBorder(true)
+
+	view.SetTextColor(tcell.ColorWhite)
+
+	return &UserList{
+		TextView: view,
+		rosters:  make(map[string][]client.Member),
+	}
+}
+
+// Update replaces the roster of a room, redrawing the pane when the room is the active one
+func (ul *UserList) Update(roomName string, members []client.Member) {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+
+	ul.rosters[roomName] = members
+	if roomName == ul.active {
+		ul.render()
+	}
+}
+
+// Switch shows the roster of a room
+func (ul *UserList) Switch(roomName string) {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+
+	ul.active = roomName
+	ul.render()
+}
+
+// Remove discards the roster of a room
+func (ul *UserList) Remove(roomName string) {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+
+	delete(ul.rosters, roomName)
+	if ul.active == roomName {
+		ul.active = ""
+		ul.render()
+	}
+}
+
+func (ul *UserList) render() {
+	members := ul.rosters[ul.active]
+
+	var text strings.Builder
+	for _, m := range members {
+		marker := "●"
+		if m.TimedOut {
+			marker = "○"
+		}
+		text.WriteString(fmt.Sprintf("%s %s\n", marker, m))
+	}
+	ul.SetText(text.String())
+	ul.SetTitle(fmt.Sprintf(" Users (%d) ", len(members)))
+}
+
+// SystemLogTitle returns the system log title, which shows the network status in the colour of its severity
+func SystemLogTitle(status string) string {
+	colour := "red"
+	switch status {
+	case client.NetworkOnline:
+		colour = "green"
+	case client.NetworkDegraded:
+		colour = "yellow"
+	}
+	return fmt.Sprintf(" System Log - [%s]%s[-] ", colour, status)
+}
+
+// InputLabel returns the input field label for a user in a room
+func InputLabel(roomName string, username string) string {
+	return fmt.Sprintf("[%s] <%s>: ", roomName, username)
+}
+
 func CreateUI(username string, roomName string) (rootLayout *tview.Flex,
     titleView *tview.TextView,
-    chatView *tview.TextView,
+    chatPages *ChatPages,
+    userList *UserList,
     systemLogView *tview.TextView,
     inputField *tview.InputField) {
 
@@ -32,21 +239,21 @@ func CreateUI(username string, roomName string) (rootLayout *tview.Flex,
 		SetWrap(true).
 		SetBorder(true)
 
-    chatView = tview.NewTextView()
-	chatView.SetTitle(" Chat ").
-		SetBorder(true)
-        
-	chatView.SetTextColor(tcell.ColorWhite)
+    chatPages = NewChatPages()
+	chatPages.View(roomName)
+
+    userList = NewUserList()
+	userList.Switch(roomName)
 
     systemLogView = tview.NewTextView()
-	systemLogView.SetTitle(" System Log ").
+	systemLogView.SetTitle(SystemLogTitle(client.NetworkOffline)).
         SetBorder(true)
 
 	systemLogView.SetTextColor(tcell.ColorWhite)
 
 
     inputField = tview.NewInputField().
-        SetLabel(fmt.Sprintf("[%s] <%s>: ", roomName, username)).
+        SetLabel(InputLabel(roomName, username)).
         SetFieldWidth(0). // Allow for full-width text input
 		SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
 		SetFieldTextColor(tcell.ColorWhite).
@@ -55,7 +262,8 @@ func CreateUI(username string, roomName string) (rootLayout *tview.Flex,
 
     mainContent := tview.NewFlex().
         SetDirection(tview.FlexColumn).
-        AddItem(chatView, 0, 2, false).  // "2" weight for chat
+        AddItem(chatPages, 0, 2, false).  // "2" weight for chat
+        AddItem(userList, 32, 0, false). // fixed width for the user list
         AddItem(systemLogView, 0, 1, false) // "1" weight for system log
 
     rootLayout = tview.NewFlex().
@@ -65,4 +273,115 @@ func CreateUI(username string, roomName string) (rootLayout *tview.Flex,
         AddItem(inputField, 1, 1, true)
 
     return
-}
\ No newline at end of file
+}
+
+// EventSink renders client events into the room chat panes and the system log. Events arrive on network goroutines,
+// so they are queued in order and rendered on the UI goroutine of app.
+type EventSink struct {
+	app           *tview.Application
+	chatPages     *ChatPages
+	userList      *UserList
+	systemLogView *tview.TextView
+
+	mu      sync.Mutex
+	pending []client.Event
+	wake    chan struct{}
+	parted  func(roomName string)
+}
+
+// NewEventSink creates a sink writing to the panes created by CreateUI. Events are rendered once app is running.
+func NewEventSink(app *tview.Application, chatPages *ChatPages, userList *UserList, systemLogView *tview.TextView) *EventSink {
+	s := &EventSink{
+		app:           app,
+		chatPages:     chatPages,
+		userList:      userList,
+		systemLogView: systemLogView,
+		wake:          make(chan struct{}, 1),
+	}
+	go s.run()
+	return s
+}
+
+// SetPartedFunc sets the handler called on the UI goroutine after the pane of a room we left on our own was removed
+func (s *EventSink) SetPartedFunc(handler func(roomName string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.parted = handler
+}
+
+// HandleEvent queues ev to be rendered. It never waits for the UI, so it is safe to call from the UI goroutine too.
+func (s *EventSink) HandleEvent(ev client.Event) {
+	s.mu.Lock()
+	s.pending = append(s.pending, ev)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run renders queued events in batches on the UI goroutine
+func (s *EventSink) run() {
+	for range s.wake {
+		s.mu.Lock()
+		batch := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+
+		s.app.QueueUpdateDraw(func() {
+			for _, ev := range batch {
+				s.render(ev)
+			}
+		})
+	}
+}
+
+// render writes room traffic to the pane of its room, rosters to the user list and everything else to the system log.
+// Events for rooms without a pane, such as late ones for a room that was left, are dropped.
+func (s *EventSink) render(ev client.Event) {
+	switch e := ev.(type) {
+	case client.MessageEvent:
+		// Direct messages have no room, so they show up in whichever room is active
+		if chatView, found := s.chatPages.Lookup(e.Room); found {
+			chatView.Write([]byte(e.String() + "\n"))
+		} else if e.Room == "" {
+			s.systemLogView.Write([]byte(e.String() + "\n"))
+		}
+	case client.HistoryEvent:
+		chatView, found := s.chatPages.Lookup(e.Room)
+		if !found {
+			return
+		}
+		chatView.Write([]byte(e.String() + "\n"))
+		for _, msg := range e.Messages {
+			chatView.Write([]byte(msg.HistoryLine() + "\n"))
+		}
+		chatView.Write([]byte(e.Footer() + "\n"))
+	case client.UnreadableEvent:
+		if chatView, found := s.chatPages.Lookup(e.Room); found {
+			chatView.Write([]byte(e.String() + "\n"))
+		}
+	case client.RosterEvent:
+		if _, found := s.chatPages.Lookup(e.Room); found {
+			s.userList.Update(e.Room, e.Members)
+		}
+	case client.PartedEvent:
+		s.chatPages.Remove(e.Room)
+		s.userList.Remove(e.Room)
+		s.systemLogView.Write([]byte(ev.String() + "\n"))
+
+		s.mu.Lock()
+		parted := s.parted
+		s.mu.Unlock()
+		if parted != nil {
+			parted(e.Room)
+		}
+	case client.NetworkStatusEvent:
+		s.systemLogView.SetTitle(SystemLogTitle(e.Status))
+		s.systemLogView.Write([]byte(ev.String() + "\n"))
+	default:
+		s.systemLogView.Write([]byte(ev.String() + "\n"))
+	}
+}